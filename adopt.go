@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pidFile returns where the pid of an adoptable command is recorded. It's
+// keyed on the working directory and command, so separate onchange sessions
+// don't adopt each other's processes, and kept out of the watch dirs.
+func (r *runner) pidFile() string {
+	wd, _ := os.Getwd()
+	sum := sha1.Sum([]byte(wd + "\x00" + r.cmdStr))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("onchange-%x.pid", sum[:6]))
+}
+
+// writePid records pid as the adoptable command.
+func (r *runner) writePid(pid int) {
+	if err := ioutil.WriteFile(r.pidFile(), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		log.Warnf("can't record pid for --adopt: %s", err)
+	}
+}
+
+// readPid returns the recorded pid, if its process is still alive.
+func (r *runner) readPid() int {
+	return readPidFile(r.pidFile())
+}
+
+// readPidFile returns the pid in the file at path, if its process is still
+// alive.
+func readPidFile(path string) int {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || !alive(pid) {
+		return 0
+	}
+	return pid
+}
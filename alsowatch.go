@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// alsoWatch adds the --also-watch paths as watch dirs of their own, for the
+// odd file or dir outside the main ones. A dir is watched with everything
+// under it; a file is watched through its directory, which is then watched
+// one level deep and only lets that file's changes through. Neither gets
+// --include's globs, only the excludes. Paths already under a watch dir are
+// left to it.
+func (r *runner) alsoWatch(paths []string) error {
+	var dirs []string
+	files := map[string][]string{}
+	var parents []string
+	for _, p := range paths {
+		p = filepath.Clean(expandPath(p))
+		i, err := os.Stat(p)
+		if err != nil {
+			return fmt.Errorf("also-watch: %s", err)
+		}
+		if root, _ := r.root(p); root != "" {
+			log.Infof("%s is already under watch dir %s", p, root)
+			continue
+		}
+		if i.IsDir() {
+			dirs = append(dirs, p)
+			continue
+		}
+		parent := filepath.Dir(p)
+		if _, ok := files[parent]; !ok {
+			parents = append(parents, parent)
+		}
+		files[parent] = append(files[parent], globEscape(filepath.Base(p)))
+	}
+
+	for _, dir := range dirs {
+		if r.addRoot(dir) {
+			r.setMatcher(dir, matcher{ex: r.match.ex})
+		}
+	}
+	for _, parent := range parents {
+		if !r.addRoot(parent) {
+			// also given as a dir, which covers the files too.
+			continue
+		}
+		if r.rootDepths == nil {
+			r.rootDepths = map[string]int{}
+		}
+		r.rootDepths[parent] = 1
+		r.setMatcher(parent, matcher{in: files[parent], ex: r.match.ex})
+	}
+	return nil
+}
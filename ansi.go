@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// --strip-ansi modes.
+const (
+	stripNever  = "never"
+	stripAuto   = "auto"
+	stripAlways = "always"
+)
+
+// ansiStripper passes output through with ANSI escape sequences taken out:
+// colors and cursor movement (CSI), titles and links (OSC), and the rest of
+// the two-byte escapes. A sequence split across writes is still dropped whole.
+type ansiStripper struct {
+	w     io.Writer
+	state int
+}
+
+// ansiStripper states.
+const (
+	ansiText   = iota
+	ansiEsc    // after ESC
+	ansiCSI    // in ESC [ ..., until a final byte
+	ansiOSC    // in ESC ] ..., until BEL or ST
+	ansiOSCEsc // after ESC in an OSC, which ends it if it's ST
+)
+
+func (s *ansiStripper) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch s.state {
+		case ansiText:
+			if b == 0x1b {
+				s.state = ansiEsc
+				continue
+			}
+			out = append(out, b)
+		case ansiEsc:
+			switch b {
+			case '[':
+				s.state = ansiCSI
+			case ']':
+				s.state = ansiOSC
+			default:
+				s.state = ansiText
+			}
+		case ansiCSI:
+			if b >= 0x40 && b <= 0x7e {
+				s.state = ansiText
+			}
+		case ansiOSC:
+			switch b {
+			case 0x07:
+				s.state = ansiText
+			case 0x1b:
+				s.state = ansiOSCEsc
+			}
+		case ansiOSCEsc:
+			s.state = ansiText
+			if b != '\\' {
+				s.state = ansiOSC
+			}
+		}
+	}
+	if _, err := s.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// plain wraps w, where the command's output goes, to strip ANSI escapes
+// with --strip-ansi always, or with auto unless w is a terminal.
+func (r *runner) plain(w io.Writer) io.Writer {
+	switch r.stripANSI {
+	case stripAlways:
+	case stripAuto:
+		if f, ok := w.(*os.File); ok && isTerminal(int(f.Fd())) {
+			return w
+		}
+	default:
+		return w
+	}
+	return &ansiStripper{w: w}
+}
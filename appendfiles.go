@@ -0,0 +1,64 @@
+package main
+
+import "os"
+
+// --append-files-empty modes.
+const (
+	appendEmptyBare = "bare"
+	appendEmptySkip = "skip"
+)
+
+// appendList returns the changed paths --append-files passes to the command:
+// sorted as for ONCHANGE_FILES, without the ones that are gone, since tools
+// given a list of files to check mostly fail on a missing one.
+func (r *runner) appendList(changed []string) []string {
+	var files []string
+	for _, p := range r.sortFiles(changed) {
+		if _, err := os.Lstat(p); err == nil {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// appends reports whether cur's command gets the changed files appended.
+func (r *runner) appends(cur *run) bool {
+	return r.appendFiles && cur.cmdStr == r.cmdStr && !cur.warm
+}
+
+// skipRun reports whether cur shouldn't run at all, with --append-files-empty
+// skip and no changed files left to pass it.
+func (r *runner) skipRun(cur *run) bool {
+	if !r.appends(cur) || r.appendEmpty != appendEmptySkip || len(r.appendList(cur.changed)) > 0 {
+		return false
+	}
+	cur.log().Info("no changed files to pass to the command, skipping")
+	return true
+}
+
+// chunk splits the files cur passes to the command into the ones for this
+// invocation and those for the ones after it, at most appendMax each. It's
+// done once per run, when it first starts; retries and later chunks carry
+// theirs over.
+func (r *runner) chunk(cur *run) {
+	if cur.listed {
+		return
+	}
+	cur.listed = true
+	cur.files = r.appendList(cur.changed)
+	if r.appendMax > 0 && len(cur.files) > r.appendMax {
+		cur.files, cur.more = cur.files[:r.appendMax], cur.files[r.appendMax:]
+		cur.log().Infof("passing the %d changed files in chunks of %d", len(cur.files)+len(cur.more), r.appendMax)
+	}
+}
+
+// nextChunk starts the command again for the next chunk of fin's files,
+// once fin has succeeded. It's part of the same run.
+func (r *runner) nextChunk(fin *run) error {
+	next := &run{id: fin.id, cmdStr: fin.cmdStr, changed: fin.changed, op: fin.op, attempt: 1, listed: true, files: fin.more}
+	if len(next.files) > r.appendMax {
+		next.files, next.more = next.files[:r.appendMax], next.files[r.appendMax:]
+	}
+	fin.log().Debugf("%d changed files left to pass", len(fin.more))
+	return r.start(next)
+}
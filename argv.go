@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// Placeholders filled in in a command given as arguments after --.
+const (
+	// placeholderPath is the most recently changed file.
+	placeholderPath = "{path}"
+
+	// placeholderPaths is every changed file. As an argument of its own it
+	// becomes one argument per file; inside a longer one, they're joined
+	// with spaces.
+	placeholderPaths = "{paths}"
+
+	// placeholderOp is what happened to the most recently changed file, like
+	// write, create, remove or rename.
+	placeholderOp = "{op}"
+)
+
+// argvString joins argv into the command string onchange logs and compares
+// commands by, quoted so it reads like the shell line it came from.
+func argvString(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// expandArgv fills in the placeholders in argv for cur. Each argument is
+// taken as is, so a path with spaces in it stays one argument. Before the
+// first change, every placeholder is empty.
+func (r *runner) expandArgv(cur *run) []string {
+	path := ""
+	if len(cur.changed) > 0 {
+		path = cur.changed[len(cur.changed)-1]
+	}
+	paths := r.sortFiles(cur.changed)
+	repl := strings.NewReplacer(
+		placeholderPath, path,
+		placeholderPaths, strings.Join(paths, " "),
+		placeholderOp, cur.op,
+	)
+
+	var out []string
+	for _, a := range r.cmdArgv {
+		if a == placeholderPaths {
+			out = append(out, paths...)
+			continue
+		}
+		out = append(out, repl.Replace(a))
+	}
+	return out
+}
+
+// runCmd returns the command for cur: the arguments given after --, with
+// their placeholders filled in, or cur's command string split up. With
+// --append-files, its chunk of the changed files goes on the end.
+func (r *runner) runCmd(cur *run) (*exec.Cmd, error) {
+	var args []string
+	if len(r.cmdArgv) == 0 || cur.cmdStr != r.cmdStr {
+		a, err := splitCommand(cur.cmdStr, r.quoteStyle)
+		if err != nil {
+			return nil, err
+		}
+		args = a
+	} else {
+		args = r.expandArgv(cur)
+	}
+
+	if r.appends(cur) {
+		r.chunk(cur)
+		args = append(args, cur.files...)
+	}
+	return r.newCmd(args)
+}
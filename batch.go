@@ -0,0 +1,23 @@
+package main
+
+import "time"
+
+// due reports whether enough has changed to run, when runs are batched with
+// --every or --min-files: either every relevant events or minFiles distinct
+// changed files have piled up since the last run, or the first change has
+// waited maxWait. The run at startup is always due. Callers must hold r.mu.
+func (r *runner) due() bool {
+	if (r.every <= 1 && r.minFiles <= 1) || r.pendingSince.IsZero() {
+		return true
+	}
+
+	if r.every > 1 && r.eventCount >= r.every {
+		return true
+	}
+
+	if r.minFiles > 1 && len(r.changed) >= r.minFiles {
+		return true
+	}
+
+	return r.maxWait > 0 && time.Since(r.pendingSince) >= r.maxWait
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// burstKey is the directory a burst of events in p is counted against: the
+// top-level entry under p's watch dir, since an archive extracts into one
+// tree, or the watch dir itself for files directly in it.
+func (r *runner) burstKey(p string) string {
+	root, rel := r.root(p)
+	if i := strings.Index(rel, "/"); i >= 0 {
+		return filepath.Join(root, filepath.FromSlash(rel[:i]))
+	}
+	return root
+}
+
+// noteBurst counts a relevant event toward its directory's burst. Callers
+// must hold r.mu.
+func (r *runner) noteBurst(p string) {
+	if r.burst <= 0 {
+		return
+	}
+	r.burstCounts[r.burstKey(p)]++
+}
+
+// burstOver is checked every tick. A directory that saw at least r.burst
+// events since the last tick is bursting, like an archive being extracted
+// into it, and holds off runs until a whole tick goes by without events in
+// it. Callers must hold r.mu.
+func (r *runner) burstOver() bool {
+	if r.burst <= 0 {
+		return true
+	}
+
+	for dir := range r.bursting {
+		if r.burstCounts[dir] == 0 {
+			log.Debugf("burst in %s is over", dir)
+			delete(r.bursting, dir)
+		}
+	}
+	for dir, n := range r.burstCounts {
+		if n >= r.burst && !r.bursting[dir] {
+			log.Debugf("burst of %d changes in %s", n, dir)
+			r.bursting[dir] = true
+			r.burstDirs[dir] = true
+		}
+	}
+	r.burstCounts = map[string]int{}
+
+	return len(r.bursting) == 0
+}
+
+// collapse replaces the changed paths under each directory that burst since
+// the last run with that directory, so the whole extraction reads as a single
+// change. Callers must hold r.mu.
+func (r *runner) collapse(changed []string) []string {
+	if len(r.burstDirs) == 0 {
+		return changed
+	}
+
+	var out []string
+	seen := map[string]bool{}
+	for _, p := range changed {
+		if key := r.burstKey(p); r.burstDirs[key] {
+			p = key
+		}
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	r.burstDirs = map[string]bool{}
+	return out
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBurstKey(t *testing.T) {
+	r := &runner{watchDirs: []string{"src", filepath.Join("src", "web")}}
+	tests := []struct {
+		p, want string
+	}{
+		{"src/x.go", "src"},
+		{"src/a/x.go", "src/a"},
+		{"src/a/b/c/x.go", "src/a"},
+		{"src/web/x.js", "src/web"},
+		{"src/web/node_modules/a/x.js", "src/web/node_modules"},
+	}
+	for _, tt := range tests {
+		p, want := filepath.FromSlash(tt.p), filepath.FromSlash(tt.want)
+		if got := r.burstKey(p); got != want {
+			t.Errorf("burstKey(%q) = %q, want %q", p, got, want)
+		}
+	}
+}
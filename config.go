@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultConfig is read, if it exists, when --config isn't given.
+const defaultConfig = ".onchange.yaml"
+
+// loadConfig sets flags from the config file. The file is YAML, a mapping
+// of the long flags' names to strings, numbers, bools or lists of those. A
+// .json file, or "-" for stdin, is instead a JSON object with the same
+// options as keys. Flags given on the command line win.
+func loadConfig(c *cobra.Command) error {
+	path, _ := c.Flags().GetString("config")
+	if path == "" {
+		if _, err := os.Stat(defaultConfig); err != nil {
+			return nil
+		}
+		path = defaultConfig
+	}
+
+	opts, err := readConfig(path, c.Flags())
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		path = "stdin"
+	}
+
+	// a list sets its flag once per item, which marks it changed after the
+	// first, so note what the command line set up front.
+	given := map[string]bool{}
+	c.Flags().Visit(func(flag *pflag.Flag) {
+		given[flag.Name] = true
+	})
+
+	for _, opt := range opts {
+		flag := c.Flags().Lookup(opt.name)
+		if flag == nil || opt.name == "config" || opt.name == "help" {
+			return fmt.Errorf("%s: unknown option %s", opt.at(path), opt.name)
+		}
+		if given[opt.name] {
+			continue
+		}
+		if err := c.Flags().Set(opt.name, opt.value); err != nil {
+			return fmt.Errorf("%s: %s: %s", opt.at(path), opt.name, err)
+		}
+	}
+	log.Debugf("loaded config from %s", path)
+	return nil
+}
+
+// readConfig reads the options from the config file at path, or from stdin.
+func readConfig(path string, flags *pflag.FlagSet) ([]configOption, error) {
+	if path == "-" {
+		return parseJSONConfig(os.Stdin, "stdin", flags)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if filepath.Ext(path) == ".json" {
+		return parseJSONConfig(f, path, flags)
+	}
+	return parseConfig(f, path, flags)
+}
+
+// configOption is one option of a config file, as its flag takes it. The
+// line is kept where the format gives it, for errors.
+type configOption struct {
+	name, value string
+	line        int
+}
+
+// at says where the option is, for errors.
+func (o configOption) at(path string) string {
+	if o.line == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s:%d", path, o.line)
+}
+
+// parseConfig reads the options from a YAML config, in order: a mapping
+// whose values are strings, numbers, bools, or lists of those.
+func parseConfig(r io.Reader, path string, flags *pflag.FlagSet) ([]configOption, error) {
+	var doc yaml.MapSlice
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	var opts []configOption
+	for _, kv := range doc {
+		name := fmt.Sprint(kv.Key)
+		list, ok := kv.Value.([]interface{})
+		if !ok {
+			list = []interface{}{kv.Value}
+		}
+
+		var items []string
+		for _, item := range list {
+			s, err := yamlValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %s", path, name, err)
+			}
+			items = append(items, s)
+		}
+		opts = append(opts, listOptions(flags, name, items)...)
+	}
+	return opts, nil
+}
+
+// parseJSONConfig reads the options from a JSON config: an object whose
+// values are strings, numbers, bools, or lists of those.
+func parseJSONConfig(r io.Reader, path string, flags *pflag.FlagSet) ([]configOption, error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	var obj map[string]interface{}
+	if err := d.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	var names []string
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var opts []configOption
+	for _, name := range names {
+		v := obj[name]
+		list, ok := v.([]interface{})
+		if !ok {
+			list = []interface{}{v}
+		}
+
+		var items []string
+		for _, item := range list {
+			s, err := jsonValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %s", path, name, err)
+			}
+			items = append(items, s)
+		}
+		opts = append(opts, listOptions(flags, name, items)...)
+	}
+	return opts, nil
+}
+
+// listOptions turns the items given for an option into options: one per
+// item for slice and array flags, so items can hold commas, and the items
+// joined with commas for other flags.
+func listOptions(flags *pflag.FlagSet, name string, items []string) []configOption {
+	var opts []configOption
+	flag := flags.Lookup(name)
+	if flag != nil && flag.Value.Type() == "stringSlice" {
+		for _, item := range items {
+			opts = append(opts, configOption{name: name, value: csvQuote(item)})
+		}
+		return opts
+	}
+	if flag != nil && flag.Value.Type() == "stringArray" {
+		for _, item := range items {
+			opts = append(opts, configOption{name: name, value: item})
+		}
+		return opts
+	}
+	return []configOption{{name: name, value: strings.Join(items, ",")}}
+}
+
+// csvQuote quotes s as a single CSV field, the way string slice flags split
+// their values.
+func csvQuote(s string) string {
+	if !strings.ContainsAny(s, ",\"\n") {
+		return s
+	}
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// jsonValue formats a JSON scalar as a flag value.
+func jsonValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("expected a string, number, bool or list of them, got %s", jsonType(v))
+	}
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case []interface{}:
+		return "a nested list"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// yamlValue formats a YAML scalar as a flag value; an option with no value
+// is empty.
+func yamlValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case int, int64, uint64, bool:
+		return fmt.Sprint(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		return "", errors.New("expected a string, number, bool or list of them, got a nested list")
+	default:
+		return "", errors.New("expected a string, number, bool or list of them, got a mapping")
+	}
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init [file]",
+	Short: "write a starter config file with every option, commented out",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runInit,
+}
+
+func init() {
+	initCmd.Flags().Bool("force", false, "overwrite the config file if it exists")
+	RootCmd.AddCommand(initCmd)
+}
+
+// runInit writes a config file listing every option with its help and
+// default, so the flags stay the one place options are described.
+func runInit(c *cobra.Command, args []string) error {
+	path := defaultConfig
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	mode := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force, _ := c.Flags().GetBool("force"); force {
+		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, mode, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "# onchange config. options are the long flags, without the dashes; flags")
+	fmt.Fprintln(w, "# given on the command line win. uncomment what you need.")
+	RootCmd.PersistentFlags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == "config" || flag.Name == "help" {
+			return
+		}
+		fmt.Fprintf(w, "\n# %s\n# %s: %s\n", flag.Usage, flag.Name, configDefault(flag))
+	})
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	log.Infof("wrote %s", path)
+	return nil
+}
+
+// configDefault formats a flag's default as a config value.
+func configDefault(flag *pflag.Flag) string {
+	if flag.Value.Type() == "string" && flag.DefValue != "" {
+		return fmt.Sprintf("%q", flag.DefValue)
+	}
+	return flag.DefValue
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestParseConfig(t *testing.T) {
+	flags := pflag.NewFlagSet("onchange", pflag.ContinueOnError)
+	addFlags(flags)
+
+	tests := []struct {
+		name, yaml string
+		want       []configOption
+		err        string
+	}{
+		{"empty", "", nil, ""},
+		{"comments only", "# command: make\n", nil, ""},
+		{
+			"inline comments",
+			"command: go test ./...  # the tests\ninclude: \"*.go\" # go only\n",
+			[]configOption{{name: "command", value: "go test ./..."}, {name: "include", value: "*.go"}},
+			"",
+		},
+		{
+			"block list",
+			"watch-dir:\n  - cmd\n  - internal\n",
+			[]configOption{{name: "watch-dir", value: "cmd"}, {name: "watch-dir", value: "internal"}},
+			"",
+		},
+		{
+			"flow list",
+			"watch-dir: [cmd, internal]\n",
+			[]configOption{{name: "watch-dir", value: "cmd"}, {name: "watch-dir", value: "internal"}},
+			"",
+		},
+		{
+			"list items with commas",
+			"exclude-in:\n  - \"a=*.{js,css}\"\nop-command:\n  - create,write=make\n",
+			[]configOption{{name: "exclude-in", value: `"a=*.{js,css}"`}, {name: "op-command", value: "create,write=make"}},
+			"",
+		},
+		{
+			"list for a plain flag",
+			"include: [\"*.go\", \"*.tmpl\"]\n",
+			[]configOption{{name: "include", value: "*.go,*.tmpl"}},
+			"",
+		},
+		{
+			"scalars",
+			"nice: 10\nmerge-output: true\npoll-interval: 1.5s\nretry-delay: 0.5\n",
+			[]configOption{{name: "nice", value: "10"}, {name: "merge-output", value: "true"}, {name: "poll-interval", value: "1.5s"}, {name: "retry-delay", value: "0.5"}},
+			"",
+		},
+		{"no value", "command:\n", []configOption{{name: "command", value: ""}}, ""},
+		{"mapping value", "exclude:\n  a: b\n", nil, "got a mapping"},
+		{"nested list", "exclude: [[a]]\n", nil, "got a nested list"},
+		{"unquoted alias", "include: *.go\n", nil, "yaml:"},
+	}
+	for _, tt := range tests {
+		got, err := parseConfig(strings.NewReader(tt.yaml), "test.yaml", flags)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want one containing %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+)
+
+// controlPrefix starts a control line: a line of the command's stdout that
+// talks to onchange instead of being printed. The only one so far is
+//
+//	@onchange:extend 30s
+//
+// which pushes the current attempt's --retry-timeout deadline back by 30s, and
+//
+//	@onchange:ready
+//
+// which a --warm-command spare prints once it can be promoted.
+const controlPrefix = "@onchange:"
+
+// control is a request from a run, parsed from one of its control lines.
+type control struct {
+	run    *run
+	extend time.Duration
+	ready  bool
+
+	// done is set for a --done-marker line rather than a control line.
+	done bool
+}
+
+// controlWriter passes a command's output through, picking out control lines
+// and sending them to the loop. Lines that can't be control lines are passed
+// on as they come; only one that starts like one is held until its newline.
+type controlWriter struct {
+	w    io.Writer
+	cur  *run
+	out  chan<- control
+	line []byte
+}
+
+func (cw *controlWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		chunk := p
+		if i >= 0 {
+			chunk = p[:i+1]
+		}
+		p = p[len(chunk):]
+
+		cw.line = append(cw.line, chunk...)
+		if !cw.maybeControl() {
+			if _, err := cw.w.Write(cw.line); err != nil {
+				return n, err
+			}
+			cw.line = cw.line[:0]
+			continue
+		}
+		if i >= 0 {
+			cw.handle(strings.TrimSpace(string(cw.line)))
+			cw.line = cw.line[:0]
+		}
+	}
+	return n, nil
+}
+
+// maybeControl reports whether the line so far is, or could still become, a
+// control line.
+func (cw *controlWriter) maybeControl() bool {
+	if len(cw.line) < len(controlPrefix) {
+		return strings.HasPrefix(controlPrefix, string(cw.line))
+	}
+	return strings.HasPrefix(string(cw.line), controlPrefix)
+}
+
+// handle parses a complete control line.
+func (cw *controlWriter) handle(line string) {
+	fields := strings.Fields(strings.TrimPrefix(line, controlPrefix))
+	c := control{run: cw.cur}
+	switch {
+	case len(fields) == 1 && fields[0] == "ready":
+		c.ready = true
+	case len(fields) == 2 && fields[0] == "extend":
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d <= 0 {
+			cw.cur.log().Warnf("bad control line: %s", line)
+			return
+		}
+		c.extend = d
+	default:
+		cw.cur.log().Warnf("bad control line: %s", line)
+		return
+	}
+
+	// never block the command's output on a loop that's busy or gone.
+	select {
+	case cw.out <- c:
+	default:
+		cw.cur.log().Warnf("dropped control line: %s", line)
+	}
+}
+
+// controlled applies a control request, if its run is still the current one.
+func (r *runner) controlled(c control) {
+	if c.done {
+		r.completed(c.run)
+		return
+	}
+	if c.ready {
+		if c.run == r.spare {
+			c.run.log().Debug("warm spare is ready")
+			c.run.ready = true
+		}
+		return
+	}
+
+	if c.run != r.cur {
+		return
+	}
+	if r.cur.timer == nil {
+		r.cur.log().Debugf("no --retry-timeout to extend by %s", c.extend)
+		return
+	}
+
+	r.cur.deadline = r.cur.deadline.Add(c.extend)
+	if !r.cur.timer.Stop() {
+		// it already fired; the timeout branch will kill the attempt.
+		return
+	}
+	r.cur.timer.Reset(time.Until(r.cur.deadline))
+	r.cur.log().Infof("timeout extended by %s", c.extend)
+}
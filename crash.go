@@ -0,0 +1,62 @@
+package main
+
+import "time"
+
+const (
+	// quickCrash is how soon after starting a failure counts toward a crash
+	// loop.
+	quickCrash = 2 * time.Second
+
+	// crashLoop is how many quick failures in a row make a crash loop.
+	crashLoop = 3
+
+	// maxRetryDelay caps the delay as a crash loop backs off.
+	maxRetryDelay = time.Minute
+)
+
+// retryDelay returns how long to wait before retrying fin. It's --retry-delay,
+// unless fin is the latest of several attempts in a row that failed right
+// after starting: then the delay doubles with each one, up to maxRetryDelay,
+// so a command that can't start doesn't spin.
+func (r *runner) retryDelay(fin *run) time.Duration {
+	if time.Since(fin.startedAt) < quickCrash {
+		r.quickCrashes++
+	} else {
+		r.quickCrashes = 0
+	}
+
+	if r.quickCrashes < crashLoop {
+		return r.retryDelayBase
+	}
+
+	d := r.retryDelayBase
+	if d < time.Second {
+		d = time.Second
+	}
+	for i := crashLoop; i < r.quickCrashes && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	fin.log().Warnf("crash loop: %d quick failures in a row, waiting %s before retrying", r.quickCrashes, d)
+	return d
+}
+
+// retryDue returns a channel that fires when the pending retry should start,
+// or nil if there isn't one.
+func (r *runner) retryDue() <-chan time.Time {
+	if r.retry == nil {
+		return nil
+	}
+	return r.retryTimer.C
+}
+
+// cancelRetry drops the pending retry, when a change starts a fresh run.
+func (r *runner) cancelRetry() {
+	if r.retry == nil {
+		return
+	}
+	r.retryTimer.Stop()
+	r.retry = nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// stopDaemon kills the process named in --daemon-pidfile, for commands that
+// fork a daemon and exit right away, leaving nothing for stop to kill. It
+// waits a moment for the daemon to go, so the next one can take its port.
+func (r *runner) stopDaemon() error {
+	if r.daemonPidFile == "" {
+		return nil
+	}
+	pid := readPidFile(r.daemonPidFile)
+	if pid == 0 {
+		return nil
+	}
+
+	log.Debugf("killing daemon %d from %s", pid, r.daemonPidFile)
+	p, err := os.FindProcess(pid)
+	if err == nil {
+		err = p.Kill()
+	}
+	if err != nil && alive(pid) {
+		return &CommandStopError{Command: r.cmdStr, Err: err}
+	}
+
+	for i := 0; i < 20 && alive(pid); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	os.Remove(r.daemonPidFile)
+	return nil
+}
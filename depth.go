@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseRootDepths parses --max-depth-in "root=depth" pairs.
+func (r *runner) parseRootDepths(pairs []string) (map[string]int, error) {
+	m, err := r.parseRootPatterns("max-depth-in", pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	depths := map[string]int{}
+	for root, vals := range m {
+		n, err := strconv.Atoi(vals[len(vals)-1])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("max-depth-in: invalid depth for %s: %s", root, vals[len(vals)-1])
+		}
+		depths[root] = n
+	}
+	return depths, nil
+}
+
+// maxDepthFor returns how many levels of dir are watched under root: its
+// --max-depth-in, capped by --max-depth. Zero means no limit.
+func (r *runner) maxDepthFor(root string) int {
+	r.roots.RLock()
+	defer r.roots.RUnlock()
+	d, ok := r.rootDepths[filepath.Clean(root)]
+	if !ok || (r.maxDepth > 0 && r.maxDepth < d) {
+		return r.maxDepth
+	}
+	return d
+}
+
+// tooDeep reports whether the directory p is past its watch dir's depth
+// limit, so its contents aren't watched. The watch dir itself is level 1.
+func (r *runner) tooDeep(p string) bool {
+	root, rel := r.root(p)
+	if root == "" {
+		return false
+	}
+
+	limit := r.maxDepthFor(root)
+	if limit < 1 {
+		return false
+	}
+
+	depth := 0
+	if rel != "." {
+		depth = strings.Count(rel, "/") + 1
+	}
+	return depth >= limit
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// commandFor picks the command an event should trigger. The first
+// --op-command for one of the event's ops wins; then removes and renames run
+// the delete command when there is one. With --op-command, an event for an
+// op none of them names is ignored, and ok is false. Otherwise it runs the
+// command for the file's extension, or the main command. When several events
+// land between ticks, the last one decides.
+func (r *runner) commandFor(e fsnotify.Event) (cmdStr string, ok bool) {
+	_, cmdStr, ok = r.ruleFor(e)
+	return cmdStr, ok
+}
+
+// ruleFor is commandFor, also describing the rule that matched, as
+// --list-rules shows it.
+func (r *runner) ruleFor(e fsnotify.Event) (rule, cmdStr string, ok bool) {
+	for _, oc := range r.opCmds {
+		if e.Op&oc.ops != 0 {
+			return "op " + opList(oc.ops), oc.cmdStr, true
+		}
+	}
+	if r.delCmdStr != "" && e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		return "op remove, rename", r.delCmdStr, true
+	}
+	if len(r.opCmds) > 0 {
+		return "any other op", "", false
+	}
+	ext := strings.TrimPrefix(filepath.Ext(e.Name), ".")
+	if cmdStr, ok := r.extCmds[ext]; ok {
+		return "extension ." + ext, cmdStr, true
+	}
+	return "any", r.cmdStr, true
+}
+
+// opCommand is a command to run for the ops in ops.
+type opCommand struct {
+	ops    fsnotify.Op
+	cmdStr string
+}
+
+// opNames are the ops --op-command takes, as fsnotify names them.
+var opNames = map[string]fsnotify.Op{
+	"create": fsnotify.Create,
+	"write":  fsnotify.Write,
+	"remove": fsnotify.Remove,
+	"rename": fsnotify.Rename,
+	"chmod":  fsnotify.Chmod,
+}
+
+// parseOpCommands parses "op[,op...]=command" pairs, keeping their order.
+// Each op can only be given once.
+func parseOpCommands(pairs []string) ([]opCommand, error) {
+	var out []opCommand
+	var seen fsnotify.Op
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i < 1 || i == len(pair)-1 {
+			return nil, fmt.Errorf("op-command: expected op=command, got %q", pair)
+		}
+
+		var oc opCommand
+		for _, name := range strings.Split(pair[:i], ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			op, ok := opNames[name]
+			if !ok {
+				return nil, fmt.Errorf("op-command: unknown op %q", name)
+			}
+			if seen&op != 0 {
+				return nil, fmt.Errorf("op-command: %s given twice", name)
+			}
+			seen |= op
+			oc.ops |= op
+		}
+		oc.cmdStr = strings.TrimSpace(pair[i+1:])
+		out = append(out, oc)
+	}
+	return out, nil
+}
+
+// opList names the ops in ops, like "create, write".
+func opList(ops fsnotify.Op) string {
+	var names []string
+	for _, name := range []string{"create", "write", "remove", "rename", "chmod"} {
+		if ops&opNames[name] != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// opMapped reports whether an --op-command names op.
+func (r *runner) opMapped(op fsnotify.Op) bool {
+	for _, oc := range r.opCmds {
+		if oc.ops&op != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseExtCommands parses "ext=command" pairs into commands keyed by file
+// extension, without the leading dot.
+func parseExtCommands(pairs []string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i < 1 || i == len(pair)-1 {
+			return nil, fmt.Errorf("ext-command: expected ext=command, got %q", pair)
+		}
+
+		ext := strings.TrimPrefix(strings.TrimSpace(pair[:i]), ".")
+		if ext == "" || strings.ContainsAny(ext, "/.") {
+			return nil, fmt.Errorf("ext-command: invalid extension %q", pair[:i])
+		}
+		if _, ok := m[ext]; ok {
+			return nil, fmt.Errorf("ext-command: %s given twice", ext)
+		}
+		m[ext] = strings.TrimSpace(pair[i+1:])
+	}
+	return m, nil
+}
+
+// queue schedules cmdStr to run on the next tick, replacing whatever was
+// scheduled before, except for the on-empty command: once the watch dirs have
+// emptied, that runs no matter what other events follow. Callers must hold
+// r.mu.
+func (r *runner) queue(cmdStr string) {
+	if cmdStr == "" {
+		// only --op-command was given, so there's no main command to run.
+		return
+	}
+	if r.resetNext && r.emptyCmdStr != "" && r.nextCmdStr == r.emptyCmdStr {
+		return
+	}
+	if !r.resetNext {
+		r.pendingSince = time.Now()
+	}
+	r.resetNext = true
+	r.nextCmdStr = cmdStr
+}
+
+// noteChanged adds p to the paths changed since the last run. Each path is
+// kept once, in the position of its first change, so a file written several
+// times before the next tick shows up as a single change. Callers must hold
+// r.mu.
+func (r *runner) noteChanged(p string) {
+	for _, c := range r.changed {
+		if c == p {
+			return
+		}
+	}
+	r.changed = append(r.changed, p)
+}
+
+// File orders for ONCHANGE_FILES.
+const (
+	orderName  = "name"
+	orderMtime = "mtime"
+)
+
+// changedEnv passes the changed paths to the command: ONCHANGE_FILE is the
+// most recent one, and ONCHANGE_FILES all of them, a line each, sorted so
+// the command sees the same list however the events happened to arrive.
+func (r *runner) changedEnv(changed []string) []string {
+	if len(changed) < 1 {
+		return nil
+	}
+	return []string{
+		"ONCHANGE_FILE=" + changed[len(changed)-1],
+		"ONCHANGE_FILES=" + strings.Join(r.sortFiles(changed), "\n"),
+	}
+}
+
+// sortFiles returns a sorted copy of paths, by name, or oldest first with
+// --file-order mtime; files that are gone sort first, ties go by name.
+func (r *runner) sortFiles(paths []string) []string {
+	sorted := append([]string(nil), paths...)
+	if r.fileOrder != orderMtime {
+		sort.Strings(sorted)
+		return sorted
+	}
+
+	mtimes := map[string]time.Time{}
+	for _, p := range sorted {
+		if i, err := os.Stat(p); err == nil {
+			mtimes[p] = i.ModTime()
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := mtimes[sorted[i]], mtimes[sorted[j]]
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+// listRules prints the table commandFor and queue dispatch by, in the order
+// the rules are checked; the first match wins. Commands are masked as they
+// are in the log.
+func (r *runner) listRules(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tMATCH\tPATTERN\tCOMMAND")
+
+	n := 0
+	rule := func(match, pattern, cmdStr string) {
+		n++
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", n, match, pattern, r.masks.mask(cmdStr))
+	}
+
+	if r.emptyCmdStr != "" {
+		rule("watch dirs empty", "-", r.emptyCmdStr)
+	}
+	for _, oc := range r.opCmds {
+		rule("op", opList(oc.ops), oc.cmdStr)
+	}
+	if r.delCmdStr != "" {
+		rule("op", "remove, rename", r.delCmdStr)
+	}
+	if len(r.opCmds) > 0 {
+		rule("any other op", "*", "(ignored)")
+		w.Flush()
+		return
+	}
+
+	var exts []string
+	for ext := range r.extCmds {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	for _, ext := range exts {
+		rule("extension", "."+ext, r.extCmds[ext])
+	}
+
+	rule("any", "*", r.cmdStr)
+	w.Flush()
+
+	if r.reloadCmdStr != "" {
+		fmt.Fprintf(out, "\nwhile %q is running, %q runs in its place\n", r.masks.mask(r.cmdStr), r.masks.mask(r.reloadCmdStr))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestNoteChanged(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{"once", []string{"a.go"}, []string{"a.go"}},
+		{"same path N times", []string{"a.go", "a.go", "a.go", "a.go"}, []string{"a.go"}},
+		{"first-seen order", []string{"b.go", "a.go", "b.go", "c.go", "a.go"}, []string{"b.go", "a.go", "c.go"}},
+		{"write chmod write", []string{"x/a.go", "x/b.go", "x/a.go", "x/a.go"}, []string{"x/a.go", "x/b.go"}},
+	}
+	for _, tt := range tests {
+		r := &runner{}
+		for _, p := range tt.paths {
+			r.noteChanged(p)
+		}
+		if !reflect.DeepEqual(r.changed, tt.want) {
+			t.Errorf("%s: changed = %q, want %q", tt.name, r.changed, tt.want)
+		}
+	}
+}
+
+func TestListRulesMasks(t *testing.T) {
+	masks, err := parseMasks([]string{"token", `hunter\d`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		r    *runner
+	}{
+		{"command", &runner{cmdStr: "deploy --token s3cret"}},
+		{"ext command", &runner{cmdStr: "make", extCmds: map[string]string{"go": "push token=s3cret"}}},
+		{"delete command", &runner{cmdStr: "make", delCmdStr: "rm -p hunter2"}},
+		{"op command", &runner{cmdStr: "make", opCmds: []opCommand{{ops: fsnotify.Write, cmdStr: "curl -H token:s3cret"}}}},
+		{"on empty", &runner{cmdStr: "make", emptyCmdStr: "notify hunter2"}},
+		{"reload command", &runner{cmdStr: "serve --token s3cret", reloadCmdStr: "kill -HUP hunter2"}},
+	}
+	for _, tt := range tests {
+		tt.r.masks = masks
+		var out bytes.Buffer
+		tt.r.listRules(&out)
+		if s := out.String(); strings.Contains(s, "s3cret") || strings.Contains(s, "hunter2") || !strings.Contains(s, masked) {
+			t.Errorf("%s: not masked:\n%s", tt.name, s)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// doneWriter passes a command's output through untouched, watching for a
+// line that's the --done-marker. The first one tells the loop the run is
+// complete.
+type doneWriter struct {
+	w      io.Writer
+	cur    *run
+	marker string
+	out    chan<- control
+	line   []byte
+	seen   bool
+}
+
+func (dw *doneWriter) Write(p []byte) (int, error) {
+	if _, err := dw.w.Write(p); err != nil {
+		return 0, err
+	}
+	if dw.seen {
+		return len(p), nil
+	}
+
+	for rest := p; len(rest) > 0; {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			dw.line = append(dw.line, rest...)
+			break
+		}
+		dw.line = append(dw.line, rest[:i]...)
+		rest = rest[i+1:]
+		if strings.TrimSpace(string(dw.line)) == dw.marker {
+			dw.seen = true
+			dw.send()
+			break
+		}
+		dw.line = dw.line[:0]
+	}
+	return len(p), nil
+}
+
+func (dw *doneWriter) send() {
+	// never block the command's output on a loop that's busy or gone.
+	select {
+	case dw.out <- control{run: dw.cur, done: true}:
+	default:
+		dw.cur.log().Warn("dropped done marker")
+	}
+}
+
+// completed marks cur complete once it's printed the done marker: it no
+// longer holds up the next run or counts towards --retry-timeout, and with
+// --done-stop it's killed.
+func (r *runner) completed(cur *run) {
+	if cur != r.cur || cur.done || cur.timedOut {
+		return
+	}
+	cur.done = true
+	cur.log().Info("command printed its done marker")
+	r.startupOver()
+	if cur.timer != nil {
+		cur.timer.Stop()
+	}
+	if r.doneStop {
+		cur.log().Debug("stopping the command after its done marker")
+		if err := cur.kill(); err != nil {
+			cur.log().Warnf("can't stop the command: %s", err)
+		}
+	}
+}
+
+// busy reports whether the current run is still working towards its done
+// marker, so the next run has to wait.
+func (r *runner) busy() bool {
+	return r.doneMarker != "" && r.cur != nil && !r.cur.done
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dumpState logs a snapshot of the runner, for working out why it's stuck:
+// what's pending, what's watched, what's running and how the last run went.
+func (r *runner) dumpState() {
+	r.mu.Lock()
+	pending := "nothing"
+	if r.resetNext {
+		pending = fmt.Sprintf("%q, since %s ago", r.nextCmdStr, time.Since(r.pendingSince).Round(time.Millisecond))
+	}
+	changed := append([]string(nil), r.changed...)
+	events := r.eventCount
+	r.mu.Unlock()
+
+	dirs := make([]string, 0, len(r.watched))
+	for dir := range r.watched {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	log.Info("state: pending run: " + pending)
+	if len(changed) > 0 {
+		log.Infof("state: changed files: %d, from %d events: %s", len(changed), events, strings.Join(changed, ", "))
+	} else {
+		log.Info("state: changed files: none")
+	}
+	log.Infof("state: watching %d directories, polling %d", len(dirs), len(r.pollDirs))
+	log.Debugf("state: watched directories: %s", strings.Join(dirs, ", "))
+
+	switch {
+	case r.cur != nil:
+		log.Infof("state: running: %q (pid %d, run %s, attempt %d), for %s", r.cur.cmdStr, r.cur.cmd.Process.Pid, r.cur.id, r.cur.attempt, time.Since(r.cur.startedAt).Round(time.Millisecond))
+	case r.adopted != 0:
+		log.Infof("state: running: adopted process %d", r.adopted)
+	case r.retry != nil:
+		log.Infof("state: running: nothing, retrying %q (attempt %d)", r.retry.cmdStr, r.retry.attempt)
+	default:
+		log.Info("state: running: nothing")
+	}
+
+	if r.stateFile != "" {
+		log.Infof("state: %d runs, %d failed, since %s", r.session.Runs, r.session.Failed, r.session.Since.Format(time.RFC3339))
+	}
+	if r.last == nil {
+		if l := r.session.Last; l != nil {
+			log.Infof("state: last run: %q (run %s, before a restart), exit code %d, %s ago", l.Command, l.ID, l.ExitCode, time.Since(l.Time).Round(time.Millisecond))
+			return
+		}
+		log.Info("state: last run: none yet")
+		return
+	}
+	log.Infof("state: last run: %q (run %s), exit code %d, %s ago", r.last.cmdStr, r.last.id, r.last.exitCode(), time.Since(r.lastRunEnd).Round(time.Millisecond))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// seedFiles records every file in the watch dirs that could trigger a run, so
+// that emptied can tell when the last of them goes away.
+func (r *runner) seedFiles() {
+	r.files = map[string]bool{}
+	for _, dir := range r.watchDirs {
+		filepath.Walk(dir, func(p string, i os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !i.IsDir() && !r.exclude(p) && r.include(p) {
+				r.files[p] = true
+			}
+			return nil
+		})
+	}
+}
+
+// emptied updates the tracked files for e and reports whether it removed the
+// last one. It only fires on that transition; once files come back it's armed
+// again.
+func (r *runner) emptied(e fsnotify.Event) bool {
+	if r.exclude(e.Name) || !r.include(e.Name) {
+		return false
+	}
+
+	before := len(r.files)
+	p := filepath.Clean(e.Name)
+
+	if e.Op&fsnotify.Create == fsnotify.Create {
+		if i, err := os.Stat(p); err == nil && !i.IsDir() {
+			r.files[p] = true
+		}
+	}
+
+	if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		delete(r.files, p)
+		// a directory moved or removed in one go takes its files with it.
+		prefix := p + string(filepath.Separator)
+		for f := range r.files {
+			if strings.HasPrefix(f, prefix) {
+				delete(r.files, f)
+			}
+		}
+	}
+
+	return before > 0 && len(r.files) == 0
+}
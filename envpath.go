@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// expandPath replaces $VAR and ${VAR} in p with their values from the
+// environment. "$$" stands for a literal "$".
+func expandPath(p string) string {
+	parts := strings.Split(p, "$$")
+	for i, part := range parts {
+		parts[i] = os.ExpandEnv(part)
+	}
+	return strings.Join(parts, "$")
+}
+
+// expandedDirs returns the values of a directory list flag with environment
+// variables expanded. A dir that only exists after expansion must exist,
+// since an unset variable usually leaves a path that makes no sense.
+func expandedDirs(c *cobra.Command, flag string) ([]string, error) {
+	dirs, _ := c.Flags().GetStringSlice(flag)
+
+	var out []string
+	for _, d := range dirs {
+		p := expandPath(d)
+		if p != d {
+			if _, err := os.Stat(p); err != nil {
+				return nil, fmt.Errorf("%s: %s expands to %s, which doesn't exist", flag, d, p)
+			}
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
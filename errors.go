@@ -0,0 +1,64 @@
+package main
+
+import "fmt"
+
+// WatchError means the watcher couldn't be set up, couldn't watch a path, or
+// failed while running.
+type WatchError struct {
+	// Path is the path being watched, if the error is about one.
+	Path string
+	Err  error
+}
+
+func (e *WatchError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("watch: %s", e.Err)
+	}
+	return fmt.Sprintf("watch %s: %s", e.Path, e.Err)
+}
+
+func (e *WatchError) Unwrap() error {
+	return e.Err
+}
+
+// WalkError means a watch dir couldn't be walked.
+type WalkError struct {
+	Path string
+	Err  error
+}
+
+func (e *WalkError) Error() string {
+	return fmt.Sprintf("walk %s: %s", e.Path, e.Err)
+}
+
+func (e *WalkError) Unwrap() error {
+	return e.Err
+}
+
+// CommandStartError means the command couldn't be started.
+type CommandStartError struct {
+	Command string
+	Err     error
+}
+
+func (e *CommandStartError) Error() string {
+	return fmt.Sprintf("start %q: %s", e.Command, e.Err)
+}
+
+func (e *CommandStartError) Unwrap() error {
+	return e.Err
+}
+
+// CommandStopError means the running command couldn't be killed.
+type CommandStopError struct {
+	Command string
+	Err     error
+}
+
+func (e *CommandStopError) Error() string {
+	return fmt.Sprintf("stop %q: %s", e.Command, e.Err)
+}
+
+func (e *CommandStopError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,28 @@
+package main
+
+import "github.com/Sirupsen/logrus"
+
+// failedRun counts fin toward --verbose-after, turning verbose logging on
+// once that many runs have failed in a row, or more, as when a streak carries
+// over from --state-file. It's only turned on if it was off, so only then is
+// it turned back off by succeededRun.
+func (r *runner) failedRun(fin *run) {
+	r.failures++
+	if r.verboseAfter < 1 || r.failures < r.verboseAfter || log.Level == logrus.DebugLevel {
+		return
+	}
+	log.SetLevel(logrus.DebugLevel)
+	r.escalated = true
+	fin.log().Warnf("%d failed runs in a row, verbose logging enabled until the command succeeds", r.failures)
+}
+
+// succeededRun resets the failure count, undoing --verbose-after.
+func (r *runner) succeededRun(fin *run) {
+	r.failures = 0
+	if !r.escalated {
+		return
+	}
+	r.escalated = false
+	log.SetLevel(logrus.InfoLevel)
+	fin.log().Info("command succeeded, verbose logging disabled again")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestFailedRun(t *testing.T) {
+	tests := []struct {
+		failures, verboseAfter int
+		level                  logrus.Level
+		escalated              bool
+	}{
+		{0, 0, logrus.InfoLevel, false},
+		{5, 0, logrus.InfoLevel, false},
+		{0, 2, logrus.InfoLevel, false},
+		{1, 2, logrus.InfoLevel, true},
+		{2, 2, logrus.InfoLevel, true},
+
+		// a streak restored from the state file can already be past it.
+		{7, 2, logrus.InfoLevel, true},
+
+		// verbose already, so it's left alone, then and after.
+		{1, 2, logrus.DebugLevel, false},
+	}
+	defer log.SetLevel(log.Level)
+	for _, tt := range tests {
+		log.SetLevel(tt.level)
+		r := &runner{failures: tt.failures, verboseAfter: tt.verboseAfter}
+		r.failedRun(&run{})
+		if r.escalated != tt.escalated {
+			t.Errorf("%d failures, --verbose-after %d, level %s: escalated = %v, want %v", tt.failures+1, tt.verboseAfter, tt.level, r.escalated, tt.escalated)
+		}
+		want := tt.level
+		if tt.escalated {
+			want = logrus.DebugLevel
+		}
+		if log.Level != want {
+			t.Errorf("%d failures, --verbose-after %d: level = %s, want %s", tt.failures+1, tt.verboseAfter, log.Level, want)
+		}
+
+		r.succeededRun(&run{})
+		if log.Level != tt.level {
+			t.Errorf("%d failures, --verbose-after %d: level after success = %s, want %s", tt.failures+1, tt.verboseAfter, log.Level, tt.level)
+		}
+	}
+}
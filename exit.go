@@ -0,0 +1,31 @@
+package main
+
+// Exit codes for when onchange stops on its own terms, rather than because
+// something went wrong (which exits 1).
+const (
+	// exitMaxDuration means --max-duration elapsed.
+	exitMaxDuration = 3
+
+	// exitRootRemoved means a watch dir was removed, without --wait-for-root.
+	exitRootRemoved = 4
+
+	// exitNoChange means --expect-change-within passed without a change.
+	exitNoChange = 5
+
+	// exitStartupTimeout means --startup-timeout passed before the watches
+	// were set up and the first run had finished.
+	exitStartupTimeout = 6
+
+	// exitInterrupted means onchange got ctrl-c or SIGTERM.
+	exitInterrupted = 130
+)
+
+// exitError stops Run and exits onchange with a specific code.
+type exitError struct {
+	code   int
+	reason string
+}
+
+func (e *exitError) Error() string {
+	return e.reason
+}
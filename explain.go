@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain <path> [op] [-- command...]",
+	Short: "trace how a change to path would be handled, step by step, with the same options as a real run",
+	Args:  cobra.ArbitraryArgs,
+	RunE:  runExplain,
+}
+
+func init() {
+	RootCmd.AddCommand(explainCmd)
+}
+
+// runExplain sets the runner up as for a real run, with the command after
+// --, if any, then traces the change instead of watching.
+func runExplain(c *cobra.Command, args []string) error {
+	n := c.Flags().ArgsLenAtDash()
+	if n < 0 {
+		n = len(args)
+	}
+	if n < 1 || n > 2 {
+		return errors.New("explain takes a path and, optionally, an op")
+	}
+	if n == 2 {
+		if _, err := parseOps(args[1]); err != nil {
+			return err
+		}
+	}
+	if err := validateArgs(c, args[n:]); err != nil {
+		return err
+	}
+	return runOnchange(c, args[n:])
+}
+
+// explainTarget returns the path and op explain was given; the op defaults
+// to write.
+func explainTarget(c *cobra.Command) (string, fsnotify.Op) {
+	args := c.Flags().Args()
+	if n := c.Flags().ArgsLenAtDash(); n >= 0 {
+		args = args[:n]
+	}
+	op := fsnotify.Write
+	if len(args) > 1 {
+		op, _ = parseOps(args[1])
+	}
+	return filepath.Clean(args[0]), op
+}
+
+// parseOps parses ops named as --op-command names them, like "create" or
+// "remove,rename".
+func parseOps(s string) (fsnotify.Op, error) {
+	var ops fsnotify.Op
+	for _, name := range strings.Split(s, ",") {
+		op, ok := opNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("unknown op %q: expected create, write, remove, rename or chmod", name)
+		}
+		ops |= op
+	}
+	return ops, nil
+}
+
+// explain writes out each decision handle would make for op on p, in order,
+// stopping at the first that rules it out. Decisions that depend on what
+// happens at the time, like --filter-command or --grow-only, are noted
+// rather than made.
+func (r *runner) explain(out io.Writer, p string, op fsnotify.Op) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	step := func(name, format string, args ...interface{}) {
+		fmt.Fprintf(w, "%s:\t%s\n", name, r.masks.mask(fmt.Sprintf(format, args...)))
+	}
+
+	step("path", "%s", p)
+	step("op", "%s", opList(op))
+
+	root, rel := r.root(p)
+	if root == "" {
+		if abs, err := filepath.Abs(p); err == nil {
+			root, rel = r.root(abs)
+		}
+	}
+	if root == "" {
+		step("watch dir", "none: it's outside every watch dir, so it's not watched")
+		return
+	}
+	how := "file events"
+	if r.polled(root) {
+		how = fmt.Sprintf("polled every %s", r.pollInterval)
+	}
+	step("watch dir", "%s, as %s, by %s", root, rel, how)
+
+	if r.isIgnoreFile(p) {
+		step("ignore file", "yes: a change reloads the ignore patterns and rescans; nothing runs")
+		return
+	}
+	if r.isMarker(p) {
+		step("ignore marker", "yes: a change rescans the watch dirs; nothing runs")
+		return
+	}
+
+	if !r.polled(root) {
+		dir := filepath.Dir(p)
+		if why := r.unwatchedBy(root, dir); why != "" {
+			step("directory", "%s isn't watched: %s", dir, why)
+			return
+		}
+		step("directory", "%s is watched", dir)
+	}
+
+	if op == fsnotify.Chmod && !r.opMapped(fsnotify.Chmod) {
+		step("rule", "a chmod on its own never runs anything")
+		return
+	}
+	if why := r.excludedBy(p); why != "" {
+		step("exclude", "yes: %s", why)
+		return
+	}
+	if why := r.excludeDirBy(p); why != "" {
+		step("exclude dir", "yes: %s", why)
+		return
+	}
+	if pat := r.excludeFileBy(p); pat != "" {
+		step("exclude file", "yes: its name matches --exclude-file %q", pat)
+		return
+	}
+	step("exclude", "no")
+
+	m := r.matcher(root)
+	if g, ok := m.includedBy(rel); !ok {
+		step("include", "no: matches none of %s", strings.Join(m.in, ", "))
+		return
+	} else if g != "" {
+		step("include", "yes: matches %q", g)
+	} else {
+		step("include", "yes: there are no include globs")
+	}
+
+	if r.growOnly {
+		step("grow only", "only if the file got bigger")
+	}
+	if r.symlinkTargets {
+		step("symlink targets", "if it's a symlink, only if it was repointed")
+	}
+	for _, d := range r.outputDirs {
+		if abs, _ := filepath.Abs(p); strings.HasPrefix(abs, d+string(filepath.Separator)) {
+			step("output dir", "ignored while the command runs, and for %s after", r.outputGrace)
+		}
+	}
+	if r.filterCmdStr != "" {
+		step("filter", "only if %s exits 0 for it", r.filterCmdStr)
+	}
+	if r.plugin != nil {
+		step("plugin", "only if the plugin says so, and it can pick another command")
+	}
+
+	rule, cmdStr, ok := r.ruleFor(fsnotify.Event{Name: p, Op: op})
+	if !ok {
+		step("rule", "%s: ignored", rule)
+		return
+	}
+	step("rule", "%s", rule)
+	step("command", "%s", cmdStr)
+
+	cur := &run{cmdStr: cmdStr, changed: []string{p}, op: strings.ToLower(op.String())}
+	var argv []string
+	if len(r.cmdArgv) > 0 && cmdStr == r.cmdStr {
+		argv = r.expandArgv(cur)
+	} else if a, err := splitCommand(cmdStr, r.quoteStyle); err == nil {
+		argv = a
+	}
+	if r.appendFiles && cmdStr == r.cmdStr {
+		argv = append(argv, p)
+	}
+	step("argv", "%q", argv)
+	if cmdStr == r.cmdStr {
+		for _, pipe := range r.pipeCmdStrs {
+			step("piped to", "%s", pipe)
+		}
+	}
+	step("env", "ONCHANGE_FILE=%s ONCHANGE_OP=%s", p, cur.op)
+}
+
+// unwatchedBy says why dir, under root, isn't watched, the way walk would
+// have skipped it, or returns "" if it is.
+func (r *runner) unwatchedBy(root, dir string) string {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return ""
+	}
+	a := root
+	for i, part := range append([]string{"."}, strings.Split(filepath.ToSlash(rel), "/")...) {
+		if i > 0 && part != "." {
+			a = filepath.Join(a, part)
+		}
+		if why := r.excludeDirBy(a); why != "" {
+			return fmt.Sprintf("%s %s", a, why)
+		}
+		if r.tooDeep(a) {
+			return fmt.Sprintf("%s is past the depth limit", a)
+		}
+	}
+	if why := r.excludedBy(dir); why != "" {
+		return why
+	}
+	return ""
+}
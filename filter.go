@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func (r *runner) exclude(p string) bool {
+	return r.excludedBy(p) != ""
+}
+
+// excludedBy says why p is excluded, or returns "" if it isn't. The exclude
+// patterns are taken in order, then those from the ignore files, and the last
+// one that matches decides, so an ignore file can negate an --exclude.
+func (r *runner) excludedBy(p string) string {
+	root, rel := r.root(p)
+	pat, ex := lastMatch(r.matcher(root).ex, p, rel)
+	from := "exclude pattern"
+	if ipat, iex := r.ignoreMatch(p); ipat != "" {
+		pat, ex, from = ipat, iex, "ignore file pattern"
+	}
+	if ex {
+		return fmt.Sprintf("matches %s %q", from, pat)
+	}
+	if r.ownFile(p) {
+		return "it's one of onchange's own files"
+	}
+	return ""
+}
+
+// ownFile reports whether p is a file onchange or the command maintains for
+// onchange's sake, the status file, the state file, the command log and its
+// rotated copy, or the daemon pidfile, whose updates would otherwise trigger
+// runs when they're inside a watch dir.
+func (r *runner) ownFile(p string) bool {
+	abs, _ := filepath.Abs(p)
+	own := []string{r.statusFile, r.stateFile, r.daemonPidFile}
+	if r.cmdLog != nil {
+		own = append(own, r.cmdLog.path, r.cmdLog.path+".1")
+	}
+	for _, f := range own {
+		if f == "" {
+			continue
+		}
+		if fabs, _ := filepath.Abs(f); fabs == abs {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultMarker is the --ignore-marker file name.
+const defaultMarker = ".onchange-ignore-marker"
+
+// excludeDir reports whether p is in, or is, a directory matching one of the
+// --exclude-dir patterns, or is a directory holding the ignore marker. Those
+// directories aren't walked or watched at all.
+func (r *runner) excludeDir(p string) bool {
+	return r.excludeDirBy(p) != ""
+}
+
+// excludeDirBy says why the directory p is excluded, or returns "" if it
+// isn't.
+func (r *runner) excludeDirBy(p string) string {
+	r.roots.RLock()
+	marker, exDirs := r.marker, r.exDirs
+	r.roots.RUnlock()
+
+	if marker != "" {
+		if _, err := os.Lstat(filepath.Join(p, marker)); err == nil {
+			return "it holds the ignore marker " + marker
+		}
+	}
+
+	if pat, ex := lastMatch(exDirs, p, r.rel(p)); ex {
+		return fmt.Sprintf("matches --exclude-dir %q", pat)
+	}
+	return ""
+}
+
+// excludeFile reports whether p's name matches one of the --exclude-file
+// patterns. Unlike excludeDir, it only stops p from triggering a run; a
+// directory that matches is still watched.
+func (r *runner) excludeFile(p string) bool {
+	return r.excludeFileBy(p) != ""
+}
+
+// excludeFileBy returns the --exclude-file pattern p's name matches, or "".
+func (r *runner) excludeFileBy(p string) string {
+	r.roots.RLock()
+	exFiles := r.exFiles
+	r.roots.RUnlock()
+
+	name := filepath.Base(p)
+	if pat, ex := lastMatch(exFiles, name, name); ex {
+		return pat
+	}
+	return ""
+}
+
+// isMarker reports whether p is an ignore marker, whose coming or going
+// changes which directories are watched.
+func (r *runner) isMarker(p string) bool {
+	r.roots.RLock()
+	defer r.roots.RUnlock()
+	return r.marker != "" && filepath.Base(p) == r.marker
+}
+
+// parseRootPatterns parses "root=pattern" pairs into patterns keyed by watch
+// dir, checking that each root is one.
+func (r *runner) parseRootPatterns(flag string, pairs []string) (map[string][]string, error) {
+	m := map[string][]string{}
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i < 1 || i == len(pair)-1 {
+			return nil, fmt.Errorf("%s: expected root=pattern, got %q", flag, pair)
+		}
+
+		root := filepath.Clean(pair[:i])
+		if !r.isRoot(root) {
+			return nil, fmt.Errorf("%s: %s is not a watch dir", flag, pair[:i])
+		}
+		m[root] = append(m[root], pair[i+1:])
+	}
+	return m, nil
+}
+
+// isRoot reports whether dir is one of the watch dirs.
+func (r *runner) isRoot(dir string) bool {
+	for _, d := range r.watchDirs {
+		if filepath.Clean(d) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// include reports whether p matches one of the include globs of its watch
+// dir, which are --include-in's if it has any and --include's otherwise.
+func (r *runner) include(p string) bool {
+	root, rel := r.root(p)
+	if rel == "" {
+		rel = p
+	}
+	return r.matcher(root).included(rel)
+}
+
+// root returns the watch dir that contains p, along with p relative to it.
+// With several watch dirs each one is tried, and the closest one wins. If p is
+// outside every watch dir, both are empty.
+func (r *runner) root(p string) (root, rel string) {
+	r.roots.RLock()
+	defer r.roots.RUnlock()
+	for _, dir := range r.watchDirs {
+		rp, err := filepath.Rel(dir, p)
+		if err != nil {
+			continue
+		}
+		rp = filepath.ToSlash(rp)
+		if rp == ".." || strings.HasPrefix(rp, "../") {
+			continue
+		}
+		if rel == "" || len(rp) < len(rel) {
+			root, rel = dir, rp
+		}
+	}
+	return root, rel
+}
+
+// rel returns p relative to the watch dir that contains it, or p as is if
+// it's outside every watch dir.
+func (r *runner) rel(p string) string {
+	if _, rp := r.root(p); rp != "" {
+		return rp
+	}
+	return p
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// passesFilter runs the filter command with the changed path as its last
+// argument, and reports whether it exited 0. The filter runs for every event
+// that gets this far, blocking the run loop while it does, so it should be
+// quick; one that takes longer than filterTimeout is killed and counts as a
+// no. Without a filter command every event passes.
+func (r *runner) passesFilter(e fsnotify.Event) bool {
+	if r.filterCmdStr == "" {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.filterTimeout)
+	defer cancel()
+
+	args, err := splitCommand(r.filterCmdStr, r.quoteStyle)
+	if err != nil {
+		log.Warnf("filter command: %s", err)
+		return false
+	}
+	args = append(args, e.Name)
+	start := time.Now()
+	err = exec.CommandContext(ctx, args[0], args[1:]...).Run()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Warnf("filter timed out after %s on %s", r.filterTimeout, e.Name)
+		return false
+	}
+	log.Debugf("filter on %s took %s: %v", e.Name, time.Since(start), err)
+	return err == nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import "syscall"
+
+// networkFS are the statfs magic numbers of filesystems whose changes may
+// happen on another machine, where inotify can't see them.
+var networkFS = map[int64]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x01021997: "9p",
+}
+
+// networkFSType returns the name of dir's filesystem if it's one that inotify
+// can't be trusted on, or "" if it's local.
+func networkFSType(dir string) string {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return ""
+	}
+	return networkFS[int64(st.Type)]
+}
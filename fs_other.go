@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// networkFSType always reports a local filesystem; detection is only
+// implemented on linux. Use --poll-dirs to poll network mounts elsewhere.
+func networkFSType(dir string) string {
+	return ""
+}
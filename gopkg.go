@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// goPackage returns the Go import path of the directory holding the changed
+// file p, found by walking up to the nearest go.mod. It returns "" outside a
+// Go module.
+func goPackage(p string) string {
+	_, pkg := goModule(p)
+	return pkg
+}
+
+// goModule returns the root of the Go module holding the changed file p,
+// along with the import path of p's directory; both are "" outside a module.
+func goModule(p string) (root, pkg string) {
+	dir, err := filepath.Abs(filepath.Dir(p))
+	if err != nil {
+		return "", ""
+	}
+
+	for root := dir; ; root = filepath.Dir(root) {
+		if mod := modulePath(filepath.Join(root, "go.mod")); mod != "" {
+			rel, err := filepath.Rel(root, dir)
+			if err != nil {
+				return "", ""
+			}
+			return root, path.Join(mod, filepath.ToSlash(rel))
+		}
+		if filepath.Dir(root) == root {
+			return "", ""
+		}
+	}
+}
+
+// packageOrder returns the Go packages holding the changed files, each once,
+// with every package after the ones it imports, as `go list -deps` has them.
+// If go list fails, they're sorted by import path instead. Files outside a
+// Go module are left out.
+func packageOrder(changed []string) []string {
+	byRoot := map[string][]string{}
+	seen := map[string]bool{}
+	var roots []string
+	for _, p := range changed {
+		root, pkg := goModule(p)
+		if pkg == "" || seen[pkg] {
+			continue
+		}
+		seen[pkg] = true
+		if _, ok := byRoot[root]; !ok {
+			roots = append(roots, root)
+		}
+		byRoot[root] = append(byRoot[root], pkg)
+	}
+	sort.Strings(roots)
+
+	var ordered []string
+	for _, root := range roots {
+		pkgs := byRoot[root]
+		sort.Strings(pkgs)
+
+		// -e keeps going past packages that are broken or gone, which a
+		// change may well have left them.
+		cmd := exec.Command("go", append([]string{"list", "-e", "-deps", "-f", "{{.ImportPath}}"}, pkgs...)...)
+		cmd.Dir = root
+		out, err := cmd.Output()
+		if err != nil {
+			log.Warnf("can't order Go packages in %s, sorting them by name: %s", root, err)
+			ordered = append(ordered, pkgs...)
+			continue
+		}
+
+		listed := map[string]bool{}
+		for _, pkg := range strings.Fields(string(out)) {
+			if seen[pkg] && !listed[pkg] {
+				listed[pkg] = true
+				ordered = append(ordered, pkg)
+			}
+		}
+		// a package go list couldn't place still gets a turn, last.
+		for _, pkg := range pkgs {
+			if !listed[pkg] {
+				ordered = append(ordered, pkg)
+			}
+		}
+	}
+	return ordered
+}
+
+// modulePath reads the module path from a go.mod file, or returns "" if
+// there's no such file.
+func modulePath(gomod string) string {
+	f, err := os.Open(gomod)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
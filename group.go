@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// group styles for --group-output, named for the CI systems that fold them.
+const (
+	groupGitHub = "github"
+	groupGitLab = "gitlab"
+)
+
+// openGroup is the run whose output group is open on stdout, if any.
+type openGroup struct {
+	id    string
+	style string
+}
+
+// beginGroup prints the marker that starts a foldable group for cur's
+// output, ending the previous run's first; groups don't nest.
+func (r *runner) beginGroup(cur *run) {
+	if r.groupStyle == "" || r.background || cur.warm {
+		return
+	}
+	r.endGroup()
+
+	title := r.masks.mask(fmt.Sprintf("onchange: %s (run %s)", cur.cmdStr, cur.id))
+	switch r.groupStyle {
+	case groupGitHub:
+		fmt.Fprintf(os.Stdout, "::group::%s\n", title)
+	case groupGitLab:
+		fmt.Fprintf(os.Stdout, "\x1b[0Ksection_start:%d:run_%s\r\x1b[0K%s\n", time.Now().Unix(), cur.id, title)
+	}
+	r.group = &openGroup{id: cur.id, style: r.groupStyle}
+}
+
+// endGroup closes the open group, if there is one.
+func (r *runner) endGroup() {
+	if r.group == nil {
+		return
+	}
+	switch r.group.style {
+	case groupGitHub:
+		fmt.Fprintln(os.Stdout, "::endgroup::")
+	case groupGitLab:
+		fmt.Fprintf(os.Stdout, "\x1b[0Ksection_end:%d:run_%s\r\x1b[0K\n", time.Now().Unix(), r.group.id)
+	}
+	r.group = nil
+}
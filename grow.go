@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// seedSizes records the size of every file in the watch dirs, as the baseline
+// for growOnly.
+func (r *runner) seedSizes() {
+	r.lastSizes = map[string]int64{}
+	for _, dir := range r.watchDirs {
+		filepath.Walk(dir, func(p string, i os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !i.IsDir() {
+				r.lastSizes[p] = i.Size()
+			}
+			return nil
+		})
+	}
+}
+
+// grew reports whether e left its file bigger than it was last seen. Shrinking
+// files, like a truncated or rotated log, just get their new size recorded, so
+// the next append counts as growth again. When growOnly is off every event
+// counts.
+func (r *runner) grew(e fsnotify.Event) bool {
+	if !r.growOnly {
+		return true
+	}
+
+	p := filepath.Clean(e.Name)
+	i, err := os.Stat(p)
+	if err != nil || i.IsDir() {
+		delete(r.lastSizes, p)
+		return false
+	}
+
+	last := r.lastSizes[p]
+	r.lastSizes[p] = i.Size()
+	return i.Size() > last
+}
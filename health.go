@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// touchHealth updates the health file's mtime, creating it if needed. It's
+// called from the main loop, so the file goes stale whenever the loop stops
+// turning over; it's also left alone while the event buffer is full, since
+// the watcher can't keep up and changes are being delayed.
+func (r *runner) touchHealth() {
+	if cap(r.events) > 0 && len(r.events) == cap(r.events) {
+		log.Debugf("event buffer is full, not touching %s", r.healthFile)
+		return
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(r.healthFile, now, now); err == nil {
+		return
+	} else if !os.IsNotExist(err) {
+		log.Warnf("touching health file: %s", err)
+		return
+	}
+
+	f, err := os.Create(r.healthFile)
+	if err != nil {
+		log.Warnf("creating health file: %s", err)
+		return
+	}
+	f.Close()
+}
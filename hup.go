@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// reloadable are the options a SIGHUP applies to the running onchange: the
+// ones that pick the watch set, and the commands. The rest take a restart.
+var reloadable = map[string]bool{
+	"watch-dir":      true,
+	"watch":          true,
+	"pattern":        true,
+	"also-watch":     true,
+	"exclude":        true,
+	"exclude-in":     true,
+	"exclude-dir":    true,
+	"exclude-file":   true,
+	"ignore-marker":  true,
+	"include":        true,
+	"include-in":     true,
+	"preset":         true,
+	"max-depth":      true,
+	"max-depth-in":   true,
+	"command":        true,
+	"delete-command": true,
+	"ext-command":    true,
+	"op-command":     true,
+	"verbose-log":    true,
+}
+
+// hangup handles a SIGHUP. It reads the command line and config file again
+// and applies what changed in the reloadable options, then re-walks the watch
+// dirs and updates the watch set. If the options can't be read, the watch set
+// is still updated, with the options in use.
+func (r *runner) hangup(w *fsnotify.Watcher) error {
+	if err := r.reloadOptions(); err != nil {
+		log.Warnf("can't reload the options, keeping the ones in use: %s", err)
+	}
+
+	added, removed, err := r.syncWatches(w)
+	if err != nil {
+		return err
+	}
+	log.Infof("reloaded watches: %d added, %d removed", len(added), len(removed))
+	return nil
+}
+
+// reloadOptions parses the watch set and the commands from the options as
+// they are now, into a scratch runner, and takes them from it. Nothing else
+// newRunner does is repeated: the targets command isn't rerun, and the plugin
+// and state file aren't loaded again. A changed command is queued to run,
+// replacing the running one; otherwise the command is left alone.
+func (r *runner) reloadOptions() error {
+	c, args, err := reparse()
+	if err != nil {
+		return err
+	}
+	nr := &runner{targetsCmdStr: r.targetsCmdStr, lastTargets: r.lastTargets}
+	if err := nr.parseWatch(c, args); err != nil {
+		return err
+	}
+
+	var changed []string
+	for name, v := range flagValues(c.Flags()) {
+		if v == r.options[name] {
+			continue
+		}
+		if !reloadable[name] {
+			log.Warnf("--%s changed to %s; that takes a restart", name, v)
+			continue
+		}
+		changed = append(changed, name)
+		r.options[name] = v
+	}
+	if len(changed) < 1 {
+		return nil
+	}
+	sort.Strings(changed)
+	log.Infof("reloaded %s", strings.Join(changed, ", "))
+
+	setLogLevel(c)
+
+	r.roots.Lock()
+	r.watchDirs, r.watchGlobs = nr.watchDirs, nr.watchGlobs
+	r.match, r.rootMatch = nr.match, nr.rootMatch
+	r.maxDepth, r.rootDepths = nr.maxDepth, nr.rootDepths
+	r.exDirs, r.exFiles, r.marker = nr.exDirs, nr.exFiles, nr.marker
+	r.roots.Unlock()
+	for dir := range r.missing {
+		if !r.isRoot(dir) {
+			delete(r.missing, dir)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cmdChanged := nr.cmdStr != r.cmdStr
+	r.cmdStr, r.cmdArgv, r.delCmdStr = nr.cmdStr, nr.cmdArgv, nr.delCmdStr
+	r.extCmds, r.opCmds = nr.extCmds, nr.opCmds
+	if cmdChanged && r.cmdStr != "" {
+		log.Infof("the command changed to %s", r.cmdStr)
+		r.queue(r.cmdStr)
+	}
+	return nil
+}
+
+// reparse parses onchange's command line again, on a fresh set of flags,
+// then reads the config file into them and checks them, as at startup.
+func reparse() (*cobra.Command, []string, error) {
+	c := &cobra.Command{}
+	addFlags(c.Flags())
+	if err := c.Flags().Parse(os.Args[1:]); err != nil {
+		return nil, nil, err
+	}
+	if path, _ := c.Flags().GetString("config"); path == "-" {
+		return nil, nil, errors.New("the config came from stdin, which can't be read again")
+	}
+	if err := loadConfig(c); err != nil {
+		return nil, nil, err
+	}
+
+	args := c.Flags().Args()
+	if err := checkArgs(c, args); err != nil {
+		return nil, nil, err
+	}
+	return c, args, nil
+}
+
+// flagValues returns the value of each flag in fs, as text.
+func flagValues(fs *pflag.FlagSet) map[string]string {
+	m := map[string]string{}
+	fs.VisitAll(func(f *pflag.Flag) {
+		m[f.Name] = f.Value.String()
+	})
+	return m
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readIgnoreFile reads exclude patterns from path, written as in a
+// .gitignore, and returns them as globs for lastMatch.
+func readIgnoreFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pats []string
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		pat := gitignoreGlob(s.Text())
+		if pat == "" {
+			continue
+		}
+		if err := checkGlob(strings.TrimPrefix(pat, "!")); err != nil {
+			return nil, fmt.Errorf("%s:%d: bad pattern %q", path, n, s.Text())
+		}
+		pats = append(pats, pat)
+	}
+	return pats, s.Err()
+}
+
+// gitignoreGlob turns a .gitignore line into the glob that matches the same
+// paths, or "" for a blank line or a comment. As in git:
+//
+//   - trailing spaces are dropped unless escaped, and \# and \! escape a
+//     leading # or !
+//   - a line starting with "!" negates
+//   - a pattern with a separator at the start or in the middle is anchored to
+//     the watch dir; any other matches at any depth
+//   - a match on a directory covers everything under it
+//
+// Unlike git, a trailing "/" doesn't keep the pattern from matching a file,
+// and anchored patterns are relative to the watch dir, not to the directory
+// the ignore file is in. Braces are literal, so they're escaped.
+func gitignoreGlob(line string) string {
+	line = strings.TrimSuffix(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+
+	neg := ""
+	if strings.HasPrefix(line, "!") {
+		neg, line = "!", line[1:]
+	}
+	line = strings.TrimSuffix(line, "/")
+	if line == "" {
+		return ""
+	}
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+
+	var b strings.Builder
+	escaped := false
+	for _, c := range line {
+		if (c == '{' || c == '}') && !escaped {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+		escaped = c == '\\' && !escaped
+	}
+	return neg + b.String() + "/**"
+}
+
+// loadIgnores (re)reads the ignore files and swaps in their patterns. A file
+// that can't be parsed keeps the patterns it had before; a missing one has
+// none.
+func (r *runner) loadIgnores() {
+	var all []string
+	for _, path := range r.ignoreFiles {
+		pats, err := readIgnoreFile(path)
+		switch {
+		case os.IsNotExist(err):
+			delete(r.ignoreByFile, path)
+		case err != nil:
+			log.Warnf("keeping previous ignore patterns: %s", err)
+		default:
+			r.ignoreByFile[path] = pats
+		}
+		all = append(all, r.ignoreByFile[path]...)
+	}
+	r.ignored.Store(all)
+}
+
+// ignoreMatch is lastMatch for the patterns from the ignore files.
+func (r *runner) ignoreMatch(p string) (pat string, ex bool) {
+	pats, _ := r.ignored.Load().([]string)
+	return lastMatch(pats, p, r.rel(p))
+}
+
+// isIgnoreFile reports whether p is one of the ignore files.
+func (r *runner) isIgnoreFile(p string) bool {
+	abs, _ := filepath.Abs(p)
+	for _, f := range r.ignoreFiles {
+		if fabs, _ := filepath.Abs(f); fabs == abs {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGitignoreGlob(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"# comment", ""},
+		{"/", ""},
+		{"*.log", "**/*.log/**"},
+		{"build", "**/build/**"},
+		{"build/", "**/build/**"},
+		{"/build", "build/**"},
+		{"doc/*.txt", "doc/*.txt/**"},
+		{"**/gen", "**/gen/**"},
+		{"a/**", "a/**/**"},
+		{"!keep.log", "!**/keep.log/**"},
+		{`\#x`, `**/\#x/**`},
+		{`\!x`, `**/\!x/**`},
+		{"x  ", "**/x/**"},
+		{`x\ `, `**/x\ /**`},
+		{"x\r", "**/x/**"},
+		{"a{b,c}", `**/a\{b,c\}/**`},
+		{`a\{b`, `**/a\{b/**`},
+	}
+	for _, tt := range tests {
+		if got := gitignoreGlob(tt.line); got != tt.want {
+			t.Errorf("gitignoreGlob(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreFileMatch(t *testing.T) {
+	tests := []struct {
+		lines []string
+		rel   string
+		want  bool
+	}{
+		// a name with no separator matches at any depth, whole.
+		{[]string{"build"}, "build", true},
+		{[]string{"build"}, "src/build", true},
+		{[]string{"build"}, "src/build/x.go", true},
+		{[]string{"build"}, "rebuild.go", false},
+		{[]string{"build"}, "builder/x.go", false},
+		{[]string{"*.log"}, "x.log", true},
+		{[]string{"*.log"}, "a/b/x.log", true},
+		{[]string{"*.log"}, "x.log.go", false},
+
+		// a separator anchors it to the watch dir.
+		{[]string{"/build"}, "build/x.go", true},
+		{[]string{"/build"}, "src/build/x.go", false},
+		{[]string{"doc/*.txt"}, "doc/a.txt", true},
+		{[]string{"doc/*.txt"}, "src/doc/a.txt", false},
+		{[]string{"doc/*.txt"}, "doc/sub/a.txt", false},
+		{[]string{"a/**/b"}, "a/x/y/b", true},
+		{[]string{"a/**"}, "a/x/y", true},
+
+		// the last match wins.
+		{[]string{"*.log", "!keep.log"}, "keep.log", false},
+		{[]string{"*.log", "!keep.log"}, "a/keep.log", false},
+		{[]string{"!keep.log", "*.log"}, "keep.log", true},
+
+		// braces are literal.
+		{[]string{"a{b,c}"}, "a{b,c}", true},
+		{[]string{"a{b,c}"}, "ab", false},
+	}
+	for _, tt := range tests {
+		var pats []string
+		for _, line := range tt.lines {
+			pats = append(pats, gitignoreGlob(line))
+		}
+		p := filepath.FromSlash(tt.rel)
+		if _, got := lastMatch(pats, p, p); got != tt.want {
+			t.Errorf("%q: %s excluded = %v, want %v", tt.lines, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestReadIgnoreFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "onchange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, ".gitignore")
+	if err := ioutil.WriteFile(path, []byte("# build output\n/bin\n\n*.log\n!keep.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readIgnoreFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"bin/**", "**/*.log/**", "!**/keep.log/**"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readIgnoreFile = %q, want %q", got, want)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("ok\n[bad\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readIgnoreFile(path); err == nil {
+		t.Error("readIgnoreFile with a bad pattern: no error")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Keys for --interactive.
+const (
+	keyRerun   = 'r'
+	keyClear   = 'c'
+	keyQuit    = 'q'
+	keyVerbose = 'v'
+)
+
+// readKeys puts the terminal on stdin in key mode and sends each keypress
+// on keys until stdin closes. The returned func restores the terminal.
+func readKeys(keys chan<- byte) (func(), error) {
+	restore, err := keyMode(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	log.Info("keys: r to rerun, c to clear the screen, v to toggle verbose logging, q to quit")
+	return restore, nil
+}
+
+// pressed acts on a key, reporting whether it means quit. Other keys are
+// ignored.
+func (r *runner) pressed(key byte) bool {
+	switch key {
+	case keyRerun:
+		log.Info("rerunning")
+		r.mu.Lock()
+		r.queue(r.cmdStr)
+		r.forced = true
+		r.mu.Unlock()
+	case keyClear:
+		fmt.Fprint(os.Stdout, "\033[H\033[2J")
+	case keyVerbose:
+		// from here on it's up to the keys, not --verbose-after.
+		r.escalated = false
+		if log.Level == logrus.DebugLevel {
+			log.Info("verbose logging disabled")
+			log.SetLevel(logrus.InfoLevel)
+		} else {
+			log.SetLevel(logrus.DebugLevel)
+			log.Info("verbose logging enabled")
+		}
+	case keyQuit:
+		return true
+	}
+	return false
+}
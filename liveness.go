@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// checkWatches makes sure the watches still deliver events, which they can
+// silently stop doing after a suspend or a remount. Each check creates and
+// removes a probe file in every watch dir; a dir whose probe wasn't heard of
+// by the next check gets its watches re-established, and since changes in the
+// gap went unseen, the command runs once to catch up, unless rescans are
+// silent.
+func (r *runner) checkWatches(w *fsnotify.Watcher) error {
+	stale := false
+	for dir := range r.liveChecks {
+		if r.missing[dir] || !r.isRoot(dir) {
+			delete(r.liveChecks, dir)
+			continue
+		}
+		log.Warnf("no file events from %s since the last check, re-establishing its watches", dir)
+		n, err := r.rewatch(w, dir)
+		if err != nil {
+			return err
+		}
+		log.Infof("re-established %d watches under %s", n, dir)
+		stale = true
+	}
+	if stale && !r.silentRescan {
+		r.mu.Lock()
+		r.queue(r.cmdStr)
+		r.sawChange = true
+		r.mu.Unlock()
+	}
+
+	for _, dir := range r.watchDirs {
+		dir = filepath.Clean(dir)
+		if r.polled(dir) || r.missing[dir] {
+			continue
+		}
+		f, err := ioutil.TempFile(dir, probePrefix)
+		if err != nil {
+			log.Debugf("can't check the watches on %s: %s", dir, err)
+			delete(r.liveChecks, dir)
+			continue
+		}
+		f.Close()
+		os.Remove(f.Name())
+		r.liveChecks[dir] = true
+	}
+	return nil
+}
+
+// probed reports whether p is a probe file, marking the watch dir it was
+// created in as live.
+func (r *runner) probed(p string) bool {
+	if !strings.HasPrefix(filepath.Base(p), probePrefix) {
+		return false
+	}
+	delete(r.liveChecks, filepath.Dir(filepath.Clean(p)))
+	return true
+}
+
+// rewatch drops every watch under dir, which may no longer be connected to
+// anything, and adds them again. It returns how many it added.
+func (r *runner) rewatch(w *fsnotify.Watcher, dir string) (int, error) {
+	delete(r.liveChecks, dir)
+	prefix := dir + string(filepath.Separator)
+	for p := range r.watched {
+		if filepath.Clean(p) == dir || strings.HasPrefix(p, prefix) {
+			w.Remove(p)
+			delete(r.watched, p)
+		}
+	}
+
+	before := len(r.watched)
+	err := r.addTree(w, dir)
+	return len(r.watched) - before, err
+}
@@ -3,25 +3,34 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 func main() {
-	RootCmd.Execute()
+	if err := RootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
 }
 
 var defaultExcludes []string = []string{
-	".git", "node_modules/", ".swo", ".swp",
+	".git", "node_modules/", ".swo", ".swp", probePrefix, statusPrefix, statePrefix,
 }
 
 var log *logrus.Logger
@@ -33,29 +42,176 @@ var RootCmd = &cobra.Command{
 	PersistentPreRun: setLogger,
 	PreRunE:          validateArgs,
 	RunE:             runOnchange,
+	Args:             cobra.ArbitraryArgs,
 }
 
 func init() {
-	RootCmd.PersistentFlags().StringP("watch-dir", "d", ".", "directory to watch")
-	RootCmd.PersistentFlags().StringP("command", "c", "", "command to run")
-	RootCmd.PersistentFlags().StringP("exclude", "e", "", "exclude pattern")
-	RootCmd.PersistentFlags().StringP("interval", "i", "1000ms", "check interval (ms/ns)")
-	RootCmd.PersistentFlags().BoolP("verbose-log", "v", false, "enable verbose logging")
+	addFlags(RootCmd.PersistentFlags())
+}
+
+// addFlags defines onchange's flags on fs. A SIGHUP defines them again on a
+// fresh set, to parse the command line and config file anew.
+func addFlags(fs *pflag.FlagSet) {
+	fs.String("config", "", "read options from this file, or JSON from stdin with - (default "+defaultConfig+", if it exists)")
+	fs.StringSliceP("watch-dir", "d", []string{"."}, "directory to watch (repeatable)")
+	fs.String("targets-command", "", "command that prints the files and dirs to watch, one per line, rerun to keep them current")
+	fs.Duration("targets-interval", 10*time.Second, "how often to rerun --targets-command")
+	fs.StringSlice("watch", nil, "glob of directories to watch, including ones created later (repeatable)")
+	fs.StringSlice("also-watch", nil, "extra file or directory to watch, outside the watch dirs, without widening them (repeatable)")
+	fs.StringP("command", "c", "", "command to run")
+	fs.StringArray("pipe-to", nil, "pipe the command's output into this command, like cmd | cmd2, without a shell (repeatable, in order)")
+	fs.String("quote-style", quoteNone, "how commands are split into arguments: none, shell or shell-escaped")
+	fs.StringSlice("ext-command", nil, "command to run instead for files with this extension, as ext=command (repeatable)")
+	fs.StringArray("op-command", nil, "command to run for these ops, as op[,op...]=command, where op is create, write, remove, rename or chmod; other ops are then ignored (repeatable)")
+	fs.String("delete-command", "", "command to run instead when files are removed or renamed")
+	fs.String("warm-command", "", "keep a spare of the command started with this, promoted with SIGUSR1 on change (unix only)")
+	fs.String("reload-command", "", "while the command is running, run this on change instead of restarting it; restarts if it fails")
+	fs.String("restart-strategy", "", "what to do with the running command on change: kill, reload (with --reload-command), signal (SIGHUP, unix only) or queue (default kill, or reload with --reload-command)")
+	fs.String("plugin", "", "go plugin (.so) whose handler decides whether and what to run for each change (linux, macos and freebsd)")
+	fs.String("filter-command", "", "command run with the changed path appended; only exit 0 triggers a run")
+	fs.Duration("filter-timeout", 2*time.Second, "kill the filter command after this long, treating it as a no")
+	fs.String("on-empty", "", "command to run once when the last file in the watch dirs is removed")
+	fs.StringP("exclude", "e", "", "exclude pattern, for both directories and files")
+	fs.StringSlice("exclude-dir", nil, "don't watch directories matching this pattern, or anything under them (repeatable)")
+	fs.StringSlice("exclude-file", nil, "don't run for files whose name matches this pattern, e.g. .log (repeatable)")
+	fs.String("ignore-marker", defaultMarker, "skip directories containing a file of this name, and everything under them; empty to disable")
+	fs.StringSlice("ignore-file", []string{".onchangeignore"}, "file of exclude patterns, reloaded when it changes (repeatable)")
+	fs.StringSlice("exclude-in", nil, "exclude pattern for one watch dir only, as dir=pattern (repeatable)")
+	fs.StringSlice("output-dir", nil, "directory the command writes to, like a build cache; ignored while it runs (repeatable)")
+	fs.String("include", "", "include globs, relative to the watch dir")
+	fs.StringSlice("include-in", nil, "include glob for one watch dir only, replacing --include there, as dir=glob (repeatable)")
+	fs.StringArray("pattern", nil, "glob to watch, like src/**/*.go, or to ignore, like !src/gen/**, in place of --watch-dir and --include (repeatable)")
+	fs.String("preset", "", "include and exclude defaults for a stack: "+presetNames())
+	fs.StringP("interval", "i", "1000ms", "check interval (ms/ns)")
+	fs.String("remote", "", "run the command on this host over ssh, as [user@]host")
+	fs.Int("remote-retries", 3, "times to retry when ssh can't connect to the remote host")
+	fs.String("daemon-pidfile", "", "for commands that fork a daemon and exit: the pidfile the daemon writes, so restarts kill it (unix only)")
+	fs.Bool("adopt", false, "run the command in its own session and adopt it, if still running, when onchange restarts (unix only)")
+	fs.Int("nice", 0, "run the command at this nice level, e.g. 10 to go easy on the rest of the machine (unix only)")
+	fs.String("umask", "", "run the command with this umask, in octal, e.g. 002 (unix only)")
+	fs.String("ionice", "", "run the command in this io class, idle or best-effort[:0-7] (linux only)")
+	fs.String("ready-port", "", "for servers: log when the command accepts connections on this port, or host:port")
+	fs.Duration("ready-timeout", 30*time.Second, "warn if the command isn't accepting connections on --ready-port after this long")
+	fs.Int("retries", 0, "rerun a failed command up to this many times")
+	fs.Duration("retry-delay", 0, "wait this long before retrying a failed command, backing off further if it keeps failing right away")
+	fs.Duration("retry-timeout", 0, "kill each attempt of the command that runs longer than this")
+	fs.Bool("keep-running", false, "leave the command running when onchange exits, instead of stopping it")
+	fs.Bool("flush-on-exit", false, "on ctrl-c or SIGTERM, run the pending command, if any, before exiting")
+	fs.Bool("parse-control", false, "act on @onchange: control lines in the command's output, like \"@onchange:extend 30s\"")
+	fs.String("done-marker", "", "a run is complete once the command prints this line, not when it exits; the next run waits until then")
+	fs.Bool("done-stop", false, "kill the command once it prints --done-marker")
+	fs.Duration("max-duration", 0, "exit after this long, stopping the command (exit code 3)")
+	fs.Duration("startup-timeout", 0, "exit if setting up the watches and the first run take longer than this, e.g. to keep CI from hanging (exit code 6)")
+	fs.Duration("expect-change-within", 0, "exit if no relevant change comes in this long after starting, e.g. to check watching works in CI (exit code 5)")
+	fs.Bool("wait-for-root", false, "when a watch dir is removed, wait for it to come back instead of exiting (exit code 4)")
+	fs.String("state-file", "", "keep run counts, failure streaks and crash loop backoff in this file, so they survive restarts of onchange")
+	fs.String("status-file", "", "write the exit code of each finished run to this file")
+	fs.Bool("osc-notify", false, "send a terminal notification (OSC 9) when each run succeeds or fails, for iTerm2 and the like")
+	fs.String("health-file", "", "touch this file periodically while onchange is working, for supervisors")
+	fs.Duration("health-interval", 10*time.Second, "how often to touch --health-file")
+	fs.Int("max-depth", 0, "watch this many levels of directories under each watch dir, counting it as 1; 0 for all")
+	fs.StringSlice("max-depth-in", nil, "max depth for one watch dir only, capped by --max-depth, as dir=depth (repeatable)")
+	fs.Duration("watch-check-interval", 0, "check this often that the watches still deliver events, e.g. after a suspend or remount, and re-establish them if not")
+	fs.Int("max-concurrent-watch-adds", 0, "add watches in batches of this many, pausing briefly between them, for systems that fail on a rush of them; 0 for no limit")
+	fs.Bool("watch-new-files-in-root-only", false, "after startup, only watch new directories created right in a watch dir, not deeper ones, to keep the watch set bounded")
+	fs.Bool("probe", true, "check at startup that the watch dirs deliver file events, polling them if not")
+	fs.StringSlice("poll-dirs", nil, "directory to watch by polling instead of file events (repeatable)")
+	fs.Bool("silent-rescan", false, "don't run for what a rescan finds, after dropped events or when a polled dir comes back; only later changes count")
+	fs.Duration("poll-interval", time.Second, "how often to scan polled directories")
+	fs.Duration("poll-min-interval", 0, "with --poll-max-interval, adapt the poll interval between these bounds")
+	fs.Duration("poll-max-interval", 0, "with --poll-min-interval, adapt the poll interval between these bounds")
+	fs.Int("poll-focus", 0, "scan all of a polled dir only every this many intervals, and the directories with recent changes every interval, for huge trees")
+	fs.Bool("poll-hash", false, "also compare the contents of polled files, to catch rewrites that keep the size and mtime; reads every file on every scan")
+	fs.Int("buffer-size", 256, "number of file events to buffer while the runner is busy")
+	fs.Bool("list-rules", false, "print which changes run which command, in the order they're matched, and exit")
+	fs.BoolP("verbose-log", "v", false, "enable verbose logging")
+	fs.Int("verbose-after", 0, "turn on verbose logging after this many failed runs in a row, until one succeeds")
+	fs.String("run-on-signal", "", "run the command when onchange gets this signal, as it would on a change: USR1 or USR2 (unix only)")
+	fs.Bool("dump-state", false, "log what onchange is doing on SIGUSR2: pending run, changed files, watches, the command and the last run (unix only)")
+	fs.Bool("interactive", false, "take keys from the terminal: r reruns, c clears the screen, v toggles verbose logging, q quits")
+	fs.StringSlice("mask", nil, "key name (token masks token=X) or regexp to redact from the log (repeatable)")
+	fs.String("pprof-addr", "", "serve pprof endpoints and metrics for onchange itself on this address")
+	fs.Bool("append-files", false, "pass the changed files that still exist to the command as extra arguments, like xargs")
+	fs.String("append-files-empty", appendEmptyBare, "with --append-files and no changed files, e.g. at startup: bare runs the command without any, skip doesn't run it")
+	fs.Int("append-files-max", 1000, "with --append-files, pass at most this many files per invocation, running the command again for the rest; 0 for no limit")
+	fs.String("file-order", orderName, "order of the changed files in ONCHANGE_FILES: name or mtime, oldest first")
+	fs.Bool("go-packages", false, "pass the changed Go packages in ONCHANGE_PACKAGES, each after the packages it imports")
+	fs.Bool("interactive-stdin", false, "connect the command's stdin to onchange's, so you can type into it")
+	fs.Bool("stdin-from-changed", false, "feed the most recently changed file to the command's stdin")
+	fs.String("strip-ansi", stripNever, "strip ANSI escapes, like colors, from the command's output: never, auto (wherever it isn't going to a terminal) or always")
+	fs.Bool("merge-output", false, "merge the command's stderr into its stdout")
+	fs.String("summary-regex", "", "log a summary of each run from the output lines matching this regexp, e.g. \"(?P<result>ok|FAIL)\"")
+	fs.String("summary-template", "", "the summary --summary-regex logs, with {name} for a named group of the last match and {matches} for the count")
+	fs.String("group-output", "", "wrap each run's output in a foldable group for CI logs: github or gitlab")
+	fs.Int("every", 0, "only run once this many relevant changes have piled up")
+	fs.Int("min-files", 0, "only run once this many distinct files have changed since the last run")
+	fs.Duration("max-wait", 0, "with --every or --min-files, run anyway once the first pending change is this old")
+	fs.Bool("symlink-targets", false, "only count events on symlinks when they're repointed at a new target")
+	fs.Bool("grow-only", false, "only run when a changed file got bigger, e.g. an appended log")
+	fs.Int("burst", 0, "treat this many changes in one directory within an interval as a single extraction, running once it's done")
+	fs.Bool("wait-stable", false, "wait for changed files to stop growing before running")
+	fs.String("command-log-file", "", "also write the command's output to this file")
+	fs.Bool("command-log-append", false, "append to the command log file instead of truncating it")
+	fs.Bool("background", false, "send the command's output only to --command-log-file, keeping the terminal for onchange's own logs")
+	fs.Bool("command-log-rotate", false, "start a new command log file every run, keeping the last one as <file>.1")
 }
 
 func setLogger(c *cobra.Command, args []string) {
 	log = logrus.New()
+	setLogLevel(c)
+}
 
+// setLogLevel applies --verbose-log, which the config file can also set.
+func setLogLevel(c *cobra.Command) {
 	if debug, _ := c.Flags().GetBool("verbose-log"); debug {
-		log.SetLevel(logrus.DebugLevel)
-		log.Debugln("verbose logging enabled")
+		if log.Level != logrus.DebugLevel {
+			log.SetLevel(logrus.DebugLevel)
+			log.Debugln("verbose logging enabled")
+		}
 	} else {
 		log.SetLevel(logrus.InfoLevel)
 	}
 }
 
 func validateArgs(c *cobra.Command, args []string) error {
-	if c, _ := c.Flags().GetString("command"); c == "" {
+	if err := loadConfig(c); err != nil {
+		return err
+	}
+	setLogLevel(c)
+	masks, _ := c.Flags().GetStringSlice("mask")
+	m, err := parseMasks(masks)
+	if err != nil {
+		return err
+	}
+	if len(m) > 0 {
+		log.Hooks.Add(m)
+	}
+	return checkArgs(c, args)
+}
+
+// checkArgs checks the flags, once the config file is in, and args, the
+// command after --, if any.
+func checkArgs(c *cobra.Command, args []string) error {
+	if pats, _ := c.Flags().GetStringArray("pattern"); len(pats) > 0 {
+		for _, flag := range []string{"watch-dir", "watch", "include", "include-in", "targets-command"} {
+			if c.Flags().Changed(flag) {
+				return fmt.Errorf("--pattern picks the watch dirs and include globs; it can't be used with --%s", flag)
+			}
+		}
+	}
+	if err := applyPreset(c); err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		// args are what came after --; explain has its own before them.
+		if c.Flags().ArgsLenAtDash() != len(c.Flags().Args())-len(args) {
+			return fmt.Errorf("unexpected argument %q; put the command after --, or give it with --command", args[0])
+		}
+		if cmd, _ := c.Flags().GetString("command"); cmd != "" {
+			return errors.New("give the command either with --command or after --, not both")
+		}
+	} else if cmd, _ := c.Flags().GetString("command"); cmd == "" && !c.Flags().Changed("op-command") {
 		return errors.New("command is required!")
 	}
 
@@ -66,14 +222,199 @@ func validateArgs(c *cobra.Command, args []string) error {
 		}
 	}
 
+	if d, _ := c.Flags().GetDuration("poll-interval"); d <= 0 {
+		return fmt.Errorf("invalid poll interval: %s", d)
+	}
+
+	pollMin, _ := c.Flags().GetDuration("poll-min-interval")
+	pollMax, _ := c.Flags().GetDuration("poll-max-interval")
+	if (pollMin > 0) != (pollMax > 0) || pollMin > pollMax {
+		return fmt.Errorf("invalid poll interval bounds: %s to %s", pollMin, pollMax)
+	}
+
+	if d, _ := c.Flags().GetDuration("health-interval"); d <= 0 {
+		return fmt.Errorf("invalid health interval: %s", d)
+	}
+
+	if n, _ := c.Flags().GetInt("nice"); n < -20 || n > 19 {
+		return fmt.Errorf("invalid nice level: %d", n)
+	}
+	if s, _ := c.Flags().GetString("umask"); s != "" {
+		if _, err := parseUmask(s); err != nil {
+			return err
+		}
+	}
+	if s, _ := c.Flags().GetString("ionice"); s != "" {
+		if _, _, err := parseIonice(s); err != nil {
+			return err
+		}
+	}
+
+	if bg, _ := c.Flags().GetBool("background"); bg {
+		if f, _ := c.Flags().GetString("command-log-file"); f == "" {
+			return errors.New("--background needs --command-log-file")
+		}
+	}
+
+	if port, _ := c.Flags().GetString("ready-port"); port != "" {
+		if _, _, err := net.SplitHostPort(readyAddr(port)); err != nil {
+			return fmt.Errorf("invalid ready port: %s", port)
+		}
+	}
+
+	if warm, _ := c.Flags().GetString("warm-command"); warm != "" {
+		for _, flag := range []string{"adopt", "remote", "pipe-to"} {
+			if c.Flags().Changed(flag) {
+				return fmt.Errorf("--warm-command can't be used with --%s", flag)
+			}
+		}
+	}
+
+	if t, _ := c.Flags().GetString("targets-command"); t != "" {
+		for _, flag := range []string{"watch-dir", "watch", "also-watch", "include-in", "max-depth-in"} {
+			if c.Flags().Changed(flag) {
+				return fmt.Errorf("--targets-command picks the watch dirs; it can't be used with --%s", flag)
+			}
+		}
+		if d, _ := c.Flags().GetDuration("targets-interval"); d <= 0 {
+			return fmt.Errorf("invalid targets interval: %s", d)
+		}
+	}
+
+	if n, _ := c.Flags().GetInt("max-depth"); n < 0 {
+		return fmt.Errorf("invalid max depth: %d", n)
+	}
+
+	if d, _ := c.Flags().GetDuration("watch-check-interval"); d != 0 && d < probeTimeout {
+		return fmt.Errorf("watch check interval must be at least %s", probeTimeout)
+	}
+
+	if n, _ := c.Flags().GetInt("max-concurrent-watch-adds"); n < 0 {
+		return fmt.Errorf("invalid max concurrent watch adds: %d", n)
+	}
+
+	if name, _ := c.Flags().GetString("run-on-signal"); name != "" {
+		sig, err := runSignal(name)
+		if err != nil {
+			return err
+		}
+		if dump, _ := c.Flags().GetBool("dump-state"); dump && sig == dumpSignal {
+			return errors.New("--dump-state already uses SIGUSR2")
+		}
+	}
+
+	if mode, _ := c.Flags().GetString("append-files-empty"); mode != appendEmptyBare && mode != appendEmptySkip {
+		return fmt.Errorf("unknown append-files-empty mode: %s", mode)
+	}
+	if n, _ := c.Flags().GetInt("append-files-max"); n < 0 {
+		return fmt.Errorf("invalid append files max: %d", n)
+	}
+
+	if n, _ := c.Flags().GetInt("poll-focus"); n < 0 {
+		return fmt.Errorf("invalid poll focus: %d", n)
+	}
+
+	if n, _ := c.Flags().GetInt("buffer-size"); n < 0 {
+		return fmt.Errorf("invalid buffer size: %d", n)
+	}
+
+	pairs, _ := c.Flags().GetStringSlice("ext-command")
+	extCmds, err := parseExtCommands(pairs)
+	if err != nil {
+		return err
+	}
+	opPairs, _ := c.Flags().GetStringArray("op-command")
+	opCmds, err := parseOpCommands(opPairs)
+	if err != nil {
+		return err
+	}
+
+	style, _ := c.Flags().GetString("quote-style")
+	if style != quoteNone && style != quoteShell && style != quoteShellEscaped {
+		return fmt.Errorf("unknown quote style: %s", style)
+	}
+	if interactive, _ := c.Flags().GetBool("interactive"); interactive {
+		if path, _ := c.Flags().GetString("config"); path == "-" {
+			return errors.New("--interactive reads keys from stdin, so it can't be used with --config -")
+		}
+	}
+	if stdin, _ := c.Flags().GetBool("interactive-stdin"); stdin {
+		for _, flag := range []string{"interactive", "stdin-from-changed", "warm-command"} {
+			if c.Flags().Changed(flag) {
+				return fmt.Errorf("--interactive-stdin gives stdin to the command; it can't be used with --%s", flag)
+			}
+		}
+		if path, _ := c.Flags().GetString("config"); path == "-" {
+			return errors.New("--interactive-stdin gives stdin to the command; it can't be used with --config -")
+		}
+	}
+	if stop, _ := c.Flags().GetBool("done-stop"); stop {
+		if marker, _ := c.Flags().GetString("done-marker"); marker == "" {
+			return errors.New("--done-stop needs --done-marker")
+		}
+	}
+	if re, _ := c.Flags().GetString("summary-regex"); re != "" {
+		if _, err := regexp.Compile(re); err != nil {
+			return fmt.Errorf("summary regex: %s", err)
+		}
+	}
+	if style, _ := c.Flags().GetString("group-output"); style != "" && style != groupGitHub && style != groupGitLab {
+		return fmt.Errorf("unknown group output style: %s", style)
+	}
+	if mode, _ := c.Flags().GetString("strip-ansi"); mode != stripNever && mode != stripAuto && mode != stripAlways {
+		return fmt.Errorf("unknown strip-ansi mode: %s", mode)
+	}
+	if order, _ := c.Flags().GetString("file-order"); order != orderName && order != orderMtime {
+		return fmt.Errorf("unknown file order: %s", order)
+	}
+	cmds := []string{}
+	for _, flag := range []string{"command", "delete-command", "reload-command", "warm-command", "on-empty", "filter-command", "targets-command"} {
+		if s, _ := c.Flags().GetString(flag); s != "" {
+			cmds = append(cmds, s)
+		}
+	}
+	for _, s := range extCmds {
+		cmds = append(cmds, s)
+	}
+	for _, oc := range opCmds {
+		cmds = append(cmds, oc.cmdStr)
+	}
+	pipe, _ := c.Flags().GetStringArray("pipe-to")
+	if adopt, _ := c.Flags().GetBool("adopt"); adopt && len(pipe) > 0 {
+		return errors.New("--pipe-to can't be used with --adopt")
+	}
+	cmds = append(cmds, pipe...)
+	for _, s := range cmds {
+		if _, err := splitCommand(s, style); err != nil {
+			return fmt.Errorf("can't split %q: %s", s, err)
+		}
+	}
+
+	for _, flag := range []string{"watch-dir", "poll-dirs"} {
+		if _, err := expandedDirs(c, flag); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func runOnchange(c *cobra.Command, args []string) error {
-	cmd, _ := c.Flags().GetString("command")
-	dir, _ := c.Flags().GetString("watch-dir")
+// newRunner sets up a runner from the flags, and args, the command after --,
+// if any. It's called again on SIGHUP, with the flags parsed anew.
+func newRunner(c *cobra.Command, args []string) (*runner, error) {
+	emptyCmd, _ := c.Flags().GetString("on-empty")
 	intStr, _ := c.Flags().GetString("interval")
-	ex, _ := c.Flags().GetString("exclude")
+	merge, _ := c.Flags().GetBool("merge-output")
+	stdinChanged, _ := c.Flags().GetBool("stdin-from-changed")
+	cmdLog, _ := c.Flags().GetString("command-log-file")
+	stable, _ := c.Flags().GetBool("wait-stable")
+	grow, _ := c.Flags().GetBool("grow-only")
+	bufSize, _ := c.Flags().GetInt("buffer-size")
+	maxDur, _ := c.Flags().GetDuration("max-duration")
+	retries, _ := c.Flags().GetInt("retries")
+	retryTimeout, _ := c.Flags().GetDuration("retry-timeout")
+	pollDirs, _ := expandedDirs(c, "poll-dirs")
+	pollInt, _ := c.Flags().GetDuration("poll-interval")
 
 	var dur time.Duration
 
@@ -88,136 +429,1083 @@ func runOnchange(c *cobra.Command, args []string) error {
 	}
 
 	r := &runner{
-		watchDir:    dir,
-		cmdStr:      cmd,
-		resetTicker: time.NewTicker(dur),
-		resetNext:   true,
-		mu:          &sync.Mutex{},
+		emptyCmdStr:      emptyCmd,
+		resetTicker:      time.NewTicker(dur),
+		mergeOutput:      merge,
+		stdinFromChanged: stdinChanged,
+		watched:          map[string]bool{},
+		waitStable:       stable,
+		growOnly:         grow,
+		sizes:            map[string]int64{},
+		events:           make(chan fsnotify.Event, bufSize),
+		maxDuration:      maxDur,
+		retries:          retries,
+		retryTimeout:     retryTimeout,
+		done:             make(chan *run),
+		pollInterval:     pollInt,
+		mu:               &sync.Mutex{},
 	}
 
-	exArr := defaultExcludes
-	if ex != "" {
-		arr := strings.Split(ex, ",")
-		for _, e := range arr {
-			exArr = append(exArr, e)
+	if r.startupTimeout, _ = c.Flags().GetDuration("startup-timeout"); r.startupTimeout > 0 {
+		r.startupDeadline = time.Now().Add(r.startupTimeout)
+	}
+
+	r.quoteStyle, _ = c.Flags().GetString("quote-style")
+	r.transport = localTransport{}
+	if host, _ := c.Flags().GetString("remote"); host != "" {
+		appendFiles, _ := c.Flags().GetBool("append-files")
+		r.transport = sshTransport{host: host, quote: r.quoteStyle != quoteNone || len(args) > 0 || appendFiles}
+		r.remoteRetries, _ = c.Flags().GetInt("remote-retries")
+	}
+
+	masks, _ := c.Flags().GetStringSlice("mask")
+	r.masks, _ = parseMasks(masks)
+
+	r.burst, _ = c.Flags().GetInt("burst")
+	r.burstCounts = map[string]int{}
+	r.bursting = map[string]bool{}
+	r.burstDirs = map[string]bool{}
+
+	r.retryDelayBase, _ = c.Flags().GetDuration("retry-delay")
+
+	r.flushOnExit, _ = c.Flags().GetBool("flush-on-exit")
+	r.keepRunning, _ = c.Flags().GetBool("keep-running")
+
+	r.nice, _ = c.Flags().GetInt("nice")
+	r.ionice, _ = c.Flags().GetString("ionice")
+	r.umask = -1
+	if s, _ := c.Flags().GetString("umask"); s != "" {
+		r.umask, _ = parseUmask(s)
+	}
+
+	r.statusFile, _ = c.Flags().GetString("status-file")
+	r.notify, _ = c.Flags().GetBool("osc-notify")
+	r.healthFile, _ = c.Flags().GetString("health-file")
+	r.healthInterval, _ = c.Flags().GetDuration("health-interval")
+
+	r.expectWithin, _ = c.Flags().GetDuration("expect-change-within")
+
+	r.waitForRoot, _ = c.Flags().GetBool("wait-for-root")
+	r.missing = map[string]bool{}
+
+	r.targetsCmdStr, _ = c.Flags().GetString("targets-command")
+	if r.targetsCmdStr != "" {
+		targets, err := r.runTargets()
+		if err != nil {
+			return nil, fmt.Errorf("targets command: %s", err)
+		}
+		r.lastTargets = targets
+		r.targetsInterval, _ = c.Flags().GetDuration("targets-interval")
+		r.targets = make(chan []string)
+	}
+
+	if err := r.parseWatch(c, args); err != nil {
+		return nil, err
+	}
+	r.nextCmdStr = r.cmdStr
+	// with only --op-command, nothing runs until a mapped op happens.
+	r.resetNext = r.cmdStr != ""
+
+	outDirs, _ := c.Flags().GetStringSlice("output-dir")
+	for _, d := range outDirs {
+		abs, err := filepath.Abs(expandPath(d))
+		if err != nil {
+			return nil, err
+		}
+		r.outputDirs = append(r.outputDirs, abs)
+	}
+	r.outputGrace = dur
+
+	if port, _ := c.Flags().GetString("ready-port"); port != "" {
+		r.readyAddr = readyAddr(port)
+		r.readyTimeout, _ = c.Flags().GetDuration("ready-timeout")
+	}
+
+	r.parseControl, _ = c.Flags().GetBool("parse-control")
+	r.control = make(chan control, 16)
+	r.doneMarker, _ = c.Flags().GetString("done-marker")
+	r.doneStop, _ = c.Flags().GetBool("done-stop")
+	r.groupStyle, _ = c.Flags().GetString("group-output")
+	if r.stateFile, _ = c.Flags().GetString("state-file"); r.stateFile != "" {
+		r.loadState()
+	}
+	r.stripANSI, _ = c.Flags().GetString("strip-ansi")
+	if re, _ := c.Flags().GetString("summary-regex"); re != "" {
+		r.summaryRe = regexp.MustCompile(re)
+		r.summaryTmpl, _ = c.Flags().GetString("summary-template")
+	}
+
+	r.daemonPidFile, _ = c.Flags().GetString("daemon-pidfile")
+	r.background, _ = c.Flags().GetBool("background")
+
+	r.reloadCmdStr, _ = c.Flags().GetString("reload-command")
+	name, _ := c.Flags().GetString("restart-strategy")
+	strategy, err := r.newRestartStrategy(name)
+	if err != nil {
+		return nil, err
+	}
+	r.strategy = strategy
+	r.warmCmdStr, _ = c.Flags().GetString("warm-command")
+	r.pipeCmdStrs, _ = c.Flags().GetStringArray("pipe-to")
+	r.filterCmdStr, _ = c.Flags().GetString("filter-command")
+	if path, _ := c.Flags().GetString("plugin"); path != "" {
+		h, err := loadPlugin(path)
+		if err != nil {
+			return nil, err
+		}
+		r.plugin = h
+	}
+
+	r.filterTimeout, _ = c.Flags().GetDuration("filter-timeout")
+
+	r.ignoreFiles, _ = c.Flags().GetStringSlice("ignore-file")
+	r.ignoreByFile = map[string][]string{}
+	r.loadIgnores()
+
+	r.symlinkTargets, _ = c.Flags().GetBool("symlink-targets")
+	r.every, _ = c.Flags().GetInt("every")
+	r.fileOrder, _ = c.Flags().GetString("file-order")
+	r.appendFiles, _ = c.Flags().GetBool("append-files")
+	r.appendEmpty, _ = c.Flags().GetString("append-files-empty")
+	r.appendMax, _ = c.Flags().GetInt("append-files-max")
+	r.packageOrder, _ = c.Flags().GetBool("go-packages")
+	r.interactive, _ = c.Flags().GetBool("interactive")
+	r.stdinTerminal, _ = c.Flags().GetBool("interactive-stdin")
+	r.dumpOnSignal, _ = c.Flags().GetBool("dump-state")
+	if name, _ := c.Flags().GetString("run-on-signal"); name != "" {
+		r.runSignal, _ = runSignal(name)
+	}
+	r.minFiles, _ = c.Flags().GetInt("min-files")
+	r.maxWait, _ = c.Flags().GetDuration("max-wait")
+
+	if cmdLog != "" {
+		r.cmdLog = &commandLog{path: cmdLog}
+		r.cmdLog.append, _ = c.Flags().GetBool("command-log-append")
+		r.cmdLog.rotate, _ = c.Flags().GetBool("command-log-rotate")
+	}
+
+	r.verboseAfter, _ = c.Flags().GetInt("verbose-after")
+	r.silentRescan, _ = c.Flags().GetBool("silent-rescan")
+	r.rootOnly, _ = c.Flags().GetBool("watch-new-files-in-root-only")
+	r.watchAddBatch, _ = c.Flags().GetInt("max-concurrent-watch-adds")
+	r.checkInterval, _ = c.Flags().GetDuration("watch-check-interval")
+	r.liveChecks = map[string]bool{}
+	r.pollMin, _ = c.Flags().GetDuration("poll-min-interval")
+	r.pollHash, _ = c.Flags().GetBool("poll-hash")
+	r.pollFocus, _ = c.Flags().GetInt("poll-focus")
+	r.pollMax, _ = c.Flags().GetDuration("poll-max-interval")
+	for _, dir := range pollDirs {
+		r.addRoot(dir)
+		r.pollDirs = append(r.pollDirs, dir)
+	}
+
+	return r, nil
+}
+
+// parseWatch sets up what r watches and runs from the options: the watch
+// dirs and their matchers, and the commands. A SIGHUP reloads these, so
+// parseWatch has no side effects outside r; with --targets-command, it takes
+// the targets from lastTargets rather than running it.
+func (r *runner) parseWatch(c *cobra.Command, args []string) error {
+	r.cmdStr, _ = c.Flags().GetString("command")
+	if len(args) > 0 {
+		r.cmdStr = argvString(args)
+	}
+	r.cmdArgv = args
+	r.delCmdStr, _ = c.Flags().GetString("delete-command")
+	pairs, _ := c.Flags().GetStringSlice("ext-command")
+	r.extCmds, _ = parseExtCommands(pairs)
+	opPairs, _ := c.Flags().GetStringArray("op-command")
+	r.opCmds, _ = parseOpCommands(opPairs)
+
+	r.watchDirs, _ = expandedDirs(c, "watch-dir")
+	r.match.ex = append([]string(nil), defaultExcludes...)
+	if ex, _ := c.Flags().GetString("exclude"); ex != "" {
+		r.match.ex = append(r.match.ex, splitPatterns(ex)...)
+	}
+	r.exDirs, _ = c.Flags().GetStringSlice("exclude-dir")
+	r.exFiles, _ = c.Flags().GetStringSlice("exclude-file")
+	r.marker, _ = c.Flags().GetString("ignore-marker")
+
+	if in, _ := c.Flags().GetString("include"); in != "" {
+		r.match.in = splitPatterns(in)
+	}
+
+	if globs, _ := c.Flags().GetStringSlice("watch"); len(globs) > 0 {
+		if !c.Flags().Changed("watch-dir") {
+			r.watchDirs = nil
 		}
+		r.watchGlobs = globs
+		r.expandGlobs()
+	}
+
+	if pats, _ := c.Flags().GetStringArray("pattern"); len(pats) > 0 {
+		if err := r.applyPatterns(pats); err != nil {
+			return err
+		}
+	}
+
+	if r.targetsCmdStr != "" {
+		r.applyTargets(r.lastTargets)
+	}
+
+	if pairs, _ := c.Flags().GetStringSlice("exclude-in"); len(pairs) > 0 {
+		m, err := r.parseRootPatterns("exclude-in", pairs)
+		if err != nil {
+			return err
+		}
+		for root, pats := range m {
+			rm := r.matcher(root)
+			rm.ex = append(append([]string(nil), rm.ex...), pats...)
+			r.setMatcher(root, rm)
+		}
+	}
+
+	r.maxDepth, _ = c.Flags().GetInt("max-depth")
+	if pairs, _ := c.Flags().GetStringSlice("max-depth-in"); len(pairs) > 0 {
+		m, err := r.parseRootDepths(pairs)
+		if err != nil {
+			return err
+		}
+		r.rootDepths = m
+	}
+
+	if pairs, _ := c.Flags().GetStringSlice("include-in"); len(pairs) > 0 {
+		m, err := r.parseRootPatterns("include-in", pairs)
+		if err != nil {
+			return err
+		}
+		for root, globs := range m {
+			rm := r.matcher(root)
+			rm.in = globs
+			r.setMatcher(root, rm)
+		}
+	}
+
+	if paths, _ := c.Flags().GetStringSlice("also-watch"); len(paths) > 0 {
+		if err := r.alsoWatch(paths); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runOnchange(c *cobra.Command, args []string) error {
+	r, err := newRunner(c, args)
+	if err != nil {
+		return err
+	}
+	r.options = flagValues(c.Flags())
+
+	if list, _ := c.Flags().GetBool("list-rules"); list {
+		r.listRules(os.Stdout)
+		return nil
+	}
+
+	if c.Name() == "explain" {
+		p, op := explainTarget(c)
+		r.explain(os.Stdout, p, op)
+		return nil
+	}
+	r.checkPriority()
+
+	doProbe, _ := c.Flags().GetBool("probe")
+	for _, dir := range r.watchDirs {
+		if r.polled(dir) {
+			continue
+		}
+		if fs := networkFSType(dir); fs != "" {
+			log.Infof("%s is on %s, polling it", dir, fs)
+			r.pollDirs = append(r.pollDirs, dir)
+			continue
+		}
+		if doProbe {
+			if err := r.startupCheck("probing the watch dirs"); err != nil {
+				return r.exit(err)
+			}
+			if err := probe(dir); err != nil {
+				log.Warnf("%s; polling it instead", err)
+				r.pollDirs = append(r.pollDirs, dir)
+			}
+		}
+	}
+
+	if r.adopt, _ = c.Flags().GetBool("adopt"); r.adopt {
+		if pid := r.readPid(); pid != 0 {
+			log.Infof("adopted running command (pid %d)", pid)
+			r.adopted = pid
+			r.resetNext = false
+		}
+	}
+
+	if addr, _ := c.Flags().GetString("pprof-addr"); addr != "" {
+		go servePprof(addr)
 	}
-	r.ex = exArr
 
 	log.Debugf("starting: %#v", r)
-	return r.Run()
+	return r.exit(r.Run())
 }
 
 type runner struct {
-	// watchDir is the directory to watch; could be relative or absolute.
-	watchDir string
+	// watchDirs are the directories to watch; could be relative or absolute.
+	watchDirs []string
+
+	// watchGlobs match directories to watch; new matches become watch dirs as they're created.
+	watchGlobs []string
 
 	// cmdStr is the command to execute on file change.
 	cmdStr string
 
+	// cmdArgv is the command as given after --, one argument per element,
+	// with placeholders for the change; cmdStr is then its quoted form.
+	cmdArgv []string
+
+	// pipeCmdStrs are commands cmdStr's output is piped through, in order.
+	pipeCmdStrs []string
+
+	// extCmds are commands to run instead of cmdStr, keyed by file extension.
+	extCmds map[string]string
+
+	// appendFiles passes the changed files to cmdStr as extra arguments, at
+	// most appendMax at a time; appendEmpty says what to do with none.
+	appendFiles bool
+	appendEmpty string
+	appendMax   int
+
+	// opCmds are commands to run for particular ops, in the order given.
+	// With any, other ops are ignored.
+	opCmds []opCommand
+
+	// delCmdStr, if set, is executed instead of cmdStr when files are removed or renamed.
+	delCmdStr string
+
+	// reloadCmdStr, if set, is executed instead of restarting cmdStr while it's still running.
+	reloadCmdStr string
+
+	// strategy decides what happens to the running command when a new run
+	// is due.
+	strategy restartStrategy
+
+	// warmCmdStr, if set, starts a spare that's promoted instead of starting
+	// cmdStr from scratch; spare is the one waiting.
+	warmCmdStr string
+	spare      *run
+
+	// emptyCmdStr, if set, is executed when the last file in the watch dirs is removed.
+	emptyCmdStr string
+
+	// plugin, if set, decides whether and what to run for each change.
+	plugin pluginHandler
+
+	// filterCmdStr, if set, is run for each event; only events it exits 0 for count.
+	filterCmdStr string
+
+	// filterTimeout bounds each run of filterCmdStr.
+	filterTimeout time.Duration
+
+	// files are the files in the watch dirs, tracked for emptyCmdStr.
+	files map[string]bool
+
 	// resetTicker is the ticker that controls checking the restart flag.
 	resetTicker *time.Ticker
 
 	// resetNext is the flag that informs the runner if a reset is needed on next tick.
 	resetNext bool
 
-	// ex are patterns to exclude
-	ex []string
+	// nextCmdStr is the command to execute on the next reset.
+	nextCmdStr string
+
+	// changed are the paths that changed since the last reset, each once, oldest first.
+	changed []string
+
+	// lastOp is what happened to the last of them, for the {op} placeholder.
+	lastOp string
+
+	// renamed is a rename event held back to see whether a create follows it.
+	renamed *fsnotify.Event
+
+	// renamedAt is when renamed came in.
+	renamedAt time.Time
+
+	// pendingSince is when resetNext was set; zero for the run at startup.
+	pendingSince time.Time
+
+	// eventCount counts relevant events since the last reset.
+	eventCount int
+
+	// every, if more than 1, batches runs until this many relevant events have happened.
+	every int
+
+	// dumpOnSignal logs the runner's state on SIGUSR2.
+	dumpOnSignal bool
+
+	// runSignal, if set, queues a run when onchange gets it.
+	runSignal os.Signal
+
+	// interactive takes keys from the terminal; forced, set by the rerun key,
+	// runs the pending command on the next tick regardless of batching.
+	interactive bool
+	forced      bool
+
+	// fileOrder is how ONCHANGE_FILES is sorted, by name or by mtime.
+	fileOrder string
+
+	// packageOrder sets ONCHANGE_PACKAGES to the changed Go packages, in
+	// dependency order.
+	packageOrder bool
+
+	// minFiles, if more than 1, batches runs until this many distinct files have changed.
+	minFiles int
+
+	// maxWait, with every or minFiles, runs anyway once the first pending event is this old.
+	maxWait time.Duration
+
+	// stdinTerminal connects the command's stdin to onchange's.
+	stdinTerminal bool
+
+	// stdinFromChanged feeds the most recently changed file to the command's stdin.
+	stdinFromChanged bool
+
+	// match holds the include globs and exclude patterns for every watch
+	// dir, and rootMatch those for one watch dir only, keyed by that dir.
+	match     matcher
+	rootMatch map[string]matcher
+
+	// exDirs are patterns for directories to skip entirely, and exFiles for
+	// file names that shouldn't trigger a run.
+	exDirs  []string
+	exFiles []string
+
+	// marker is the name of a file that keeps its directory from being watched.
+	marker string
+
+	// ignoreFiles hold more exclude patterns, one per line, reloaded when they change.
+	ignoreFiles []string
+
+	// ignoreByFile are the patterns last read from each ignore file.
+	ignoreByFile map[string][]string
+
+	// ignored holds the patterns from every ignore file, as a []string. It's
+	// swapped out whole on reload, since pollers read it concurrently.
+	ignored atomic.Value
+
+	// outputDirs are absolute dirs the command writes to; changes in them are
+	// ignored while it runs, and for outputGrace after it finishes.
+	outputDirs  []string
+	outputGrace time.Duration
+	lastRunEnd  time.Time
+
+	// last is the last current run to finish.
+	last *run
+
+	// readyAddr, if set, is dialed after each start until the command accepts
+	// connections, for up to readyTimeout.
+	readyAddr    string
+	readyTimeout time.Duration
+
+	// parseControl picks control lines out of the command's stdout and sends
+	// them down control.
+	parseControl bool
+	control      chan control
+
+	// doneMarker, if set, is the output line that means a run is complete;
+	// until then, the next run waits. doneStop kills the command once it's
+	// printed it.
+	doneMarker string
+	doneStop   bool
+
+	// stateFile, if set, keeps session across restarts of onchange.
+	stateFile string
+	session   sessionState
+
+	// stripANSI is the --strip-ansi mode.
+	stripANSI string
+
+	// summaryRe, if set, picks the lines of a run's output that are logged,
+	// through summaryTmpl, as a summary when it finishes.
+	summaryRe   *regexp.Regexp
+	summaryTmpl string
+
+	// groupStyle, if set, is how each run's output is wrapped in a foldable
+	// group for CI logs; group is the one that's open.
+	groupStyle string
+	group      *openGroup
+
+	// daemonPidFile, if set, names the daemon the command forks, killed along
+	// with the command.
+	daemonPidFile string
+
+	// background sends the command's output to cmdLog only, not the terminal.
+	background bool
+
+	// mergeOutput sends the command's stderr to stdout instead of stderr.
+	mergeOutput bool
+
+	// cmdLog, if set, gets a copy of the command's output.
+	cmdLog *commandLog
+
+	// waitStable holds off a run until the changed files stop growing.
+	waitStable bool
+
+	// sizes are the last seen sizes of changed files, for waitStable.
+	sizes map[string]int64
+
+	// events buffers file events between the watcher and the run loop.
+	events chan fsnotify.Event
+
+	// maxDuration, if set, is how long to run before stopping the command and exiting.
+	maxDuration time.Duration
+
+	// growOnly only counts changes that made a file bigger.
+	growOnly bool
+
+	// lastSizes are the last seen sizes of every file, for growOnly.
+	lastSizes map[string]int64
+
+	// symlinkTargets ignores events on symlinks that don't change their target.
+	symlinkTargets bool
+
+	// links are the targets of every symlink in the watch dirs, when only
+	// repointed symlinks should count.
+	links map[string]string
+
+	// maxDepth limits how many levels of directories are watched under each
+	// watch dir, and rootDepths under one watch dir only, keyed by that dir.
+	maxDepth   int
+	rootDepths map[string]int
+
+	// rootOnly keeps watches added after startup to directories created
+	// right in a watch dir.
+	rootOnly bool
+
+	// watched is the set of directories currently registered with the watcher.
+	watched map[string]bool
+
+	// watchAddBatch, if set, is how many watches are added before pausing;
+	// watchAdds counts them.
+	watchAddBatch int
+	watchAdds     int
+
+	// checkInterval, if set, is how often the watches are checked for going
+	// stale; liveChecks holds the watch dirs whose probe hasn't come back.
+	checkInterval time.Duration
+	liveChecks    map[string]bool
+
+	// burst is how many changes in one directory within a tick count as a mass
+	// extraction into it; zero turns burst detection off.
+	burst int
+
+	// burstCounts are the changes per directory since the last tick.
+	burstCounts map[string]int
+
+	// bursting are the directories still mid-burst.
+	bursting map[string]bool
+
+	// burstDirs are the directories that burst since the last run.
+	burstDirs map[string]bool
+
+	// masks redact secrets from the log and from errors.
+	masks masker
+
+	// quoteStyle is how command strings are split into arguments.
+	quoteStyle string
+
+	// flushOnExit runs the pending command when onchange is interrupted.
+	flushOnExit bool
+
+	// keepRunning leaves the command running when Run returns.
+	keepRunning bool
+
+	// umask, unless -1, is the command's umask.
+	umask int
+
+	// nice and ionice lower the command's cpu and io priority.
+	nice   int
+	ionice string
+
+	// statusFile, if set, gets the exit code of each run as it finishes.
+	statusFile string
+
+	// notify sends the terminal a notification as each run finishes.
+	notify bool
+
+	// healthFile, if set, is touched every healthInterval while the loop runs.
+	healthFile     string
+	healthInterval time.Duration
+
+	// expectWithin, if set, is how soon after starting a relevant change must
+	// come in; sawChange is set by the first one.
+	expectWithin time.Duration
+	sawChange    bool
+
+	// targetsCmdStr, if set, prints the paths to watch; it's rerun every
+	// targetsInterval, with new lists arriving on targets. lastTargets is
+	// the list in effect.
+	targetsCmdStr   string
+	targetsInterval time.Duration
+	targets         chan []string
+	lastTargets     []string
+
+	// waitForRoot keeps going when a watch dir is removed, waiting for it to come back.
+	waitForRoot bool
+
+	// missing are watch dirs that were removed, with waitForRoot.
+	missing map[string]bool
+
+	// retries is how many times a failed run is retried.
+	retries int
+
+	// retryDelayBase is how long to wait before a retry, outside crash loops;
+	// quickCrashes counts failures in a row right after starting. retry is
+	// the run waiting on retryTimer to be retried.
+	retryDelayBase time.Duration
+	quickCrashes   int
+	retry          *run
+	retryTimer     *time.Timer
+
+	// retryTimeout, if set, kills each attempt that runs longer.
+	retryTimeout time.Duration
+
+	// startupTimeout, if set, bounds the walk, probes and first run, which
+	// have to be done by startupDeadline; startupDone is set once they are.
+	startupTimeout  time.Duration
+	startupDeadline time.Time
+	startupDone     bool
+
+	// failures counts runs that failed in a row, retries included. After
+	// verboseAfter of them, verbose logging is turned on, and escalated is
+	// set until a run succeeds and turns it back off.
+	failures     int
+	verboseAfter int
+	escalated    bool
+
+	// silentRescan takes what a rescan finds as a baseline, rather than as
+	// changes to run for.
+	silentRescan bool
+
+	// pollDirs are watched by polling instead of fsnotify.
+	pollDirs []string
+
+	// pollInterval is how often pollDirs are scanned.
+	pollInterval time.Duration
+
+	// pollMin and pollMax, when set, bound an adaptive poll interval.
+	pollMin, pollMax time.Duration
+
+	// pollHash compares polled files by their contents too, not just their
+	// size and mtime.
+	pollHash bool
+
+	// pollFocus, if over 1, scans all of a polled dir only every pollFocus
+	// intervals, and the directories with recent changes every interval.
+	pollFocus int
+
+	// transport runs commands, here or on a remote host.
+	transport transport
+
+	// remoteRetries is how many times in a row a run is retried when ssh can't connect.
+	remoteRetries int
+
+	// connFailures counts consecutive runs that failed to connect to the remote host.
+	connFailures int
+
+	// adopt starts commands detached from onchange, recording their pid so a
+	// later onchange can adopt them instead of starting over.
+	adopt bool
+
+	// adopted is the pid of a command left running by a previous onchange.
+	adopted int
+
+	// cur is the command currently running, if any.
+	cur *run
+
+	// done receives runs as they exit.
+	done chan *run
 
 	mu *sync.Mutex
+
+	// options are the flags in effect, as text, to tell what a SIGHUP
+	// changed.
+	options map[string]string
+
+	// roots guards watchDirs, rootMatch, maxDepth, rootDepths, exDirs,
+	// exFiles and marker, which the pollers read while the main loop adds
+	// watch dirs, retargets them or reloads them on SIGHUP.
+	roots sync.RWMutex
 }
 
-// Run is the main logic that runs the onChange app.
+// Run is the main logic that runs the onChange app. Failures come back as a
+// *WatchError, *WalkError, *CommandStartError or *CommandStopError wrapping the
+// cause, so callers can tell them apart with errors.As.
 // The core for/select statement handles the following events:
 //
-//	- fsnotify.Event: any event that should trigger a restart should set the "shouldRestart"
-//										boolean on the watcher, so that the tick-checker restarts the application on the next tick.
+//   - fsnotify.Event: any event that should trigger a restart should set the "shouldRestart"
+//     boolean on the watcher, so that the tick-checker restarts the application on the next tick.
+//     new directories get watched as they're created.
+//
+//   - resetTicker: a ticker that checks the flag, and executes a reset if it's been set.
+//
+//   - done: a run exited; failed runs are retried while they have attempts left.
+//
+//   - timeout: the current attempt ran past --retry-timeout and gets killed.
 //
-//	-	resetTicker: a ticker that checks the flag, and executes a reset if it's been set.
+//   - retryDue: a failed run's --retry-delay is up and it gets retried.
 //
-//	- fsnotify.Error: reports the error and exits the program.
+//   - targets: a new list from --targets-command replaces the watch dirs.
 //
+//   - control: a control line from the command's output, with --parse-control.
 //
+//   - fsnotify.Error: reports the error, as a *WatchError, and exits the program.
+//
+//   - deadline: fires once --max-duration has passed; stops the command and exits.
+//
+//   - health: touches --health-file, so a supervisor can tell the loop is alive.
+//
+//   - liveness: with --watch-check-interval, checks the watches still deliver
+//     events, re-establishing stale ones.
+//
+//   - startup: fires once --startup-timeout has passed; exits unless the first run has finished.
+//
+//   - expect: fires once --expect-change-within has passed; exits unless a change came in.
+//
+//   - interrupt: ctrl-c or SIGTERM exits; with --flush-on-exit, the pending command runs first.
+//
+//   - SIGHUP: reloads the options that pick the watch set and the commands, then re-walks the
+//     watch dirs and updates the watch set, leaving the command alone unless it changed.
 func (r *runner) Run() error {
 	w, err := fsnotify.NewWatcher()
 	if err != nil {
+		return &WatchError{Err: err}
+	}
+	if _, _, err := r.syncWatches(w); err != nil {
 		return err
 	}
-	filepath.Walk(r.watchDir, func(p string, i os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
 
-		if !i.IsDir() {
-			return nil
-		}
+	defer r.saveState()
+	defer r.endGroup()
 
-		if r.exclude(p) {
-			return nil
+	// however Run returns, the command goes with it, unless it's meant to
+	// outlive onchange.
+	defer func() {
+		r.stopSpare()
+		if r.adopt || r.keepRunning {
+			return
+		}
+		if err := r.stop(); err != nil {
+			log.Warnf("can't stop the command on exit: %s", err)
 		}
+	}()
 
-		log.Debugf("watching %s", p)
-		return w.Add(p)
-	})
-	if err := w.Add(r.watchDir); err != nil {
+	if r.emptyCmdStr != "" {
+		r.seedFiles()
+	}
+	if r.growOnly {
+		r.seedSizes()
+	}
+	if r.symlinkTargets {
+		r.seedLinks()
+	}
+
+	go r.drain(w)
+	if err := r.startSpare(); err != nil {
 		return err
 	}
+	if r.targetsCmdStr != "" {
+		go r.refreshTargets()
+	}
+	for _, dir := range r.pollDirs {
+		go r.poll(dir)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
-	var cmd *exec.Cmd = r.newCmd()
-	var done = make(chan error)
+	dump := make(chan os.Signal, 1)
+	if r.dumpOnSignal && dumpSignal != nil {
+		signal.Notify(dump, dumpSignal)
+	}
+	forceRun := make(chan os.Signal, 1)
+	if r.runSignal != nil {
+		signal.Notify(forceRun, r.runSignal)
+	}
+
+	var keys chan byte
+	if r.interactive {
+		keys = make(chan byte)
+		restore, err := readKeys(keys)
+		if err != nil {
+			log.Warnf("--interactive needs a terminal on stdin, ignoring it: %s", err)
+		} else {
+			defer restore()
+		}
+	}
+
+	var deadline <-chan time.Time
+	if r.maxDuration > 0 {
+		deadline = time.After(r.maxDuration)
+	}
+
+	// the walk is done; with no first run to wait for, so is startup.
+	if !r.resetNext {
+		r.startupOver()
+	}
+	startup := r.startupExpired()
+
+	var expect <-chan time.Time
+	if r.expectWithin > 0 {
+		expect = time.After(r.expectWithin)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	var liveness <-chan time.Time
+	if r.checkInterval > 0 {
+		t := time.NewTicker(r.checkInterval)
+		defer t.Stop()
+		liveness = t.C
+	}
+
+	var health <-chan time.Time
+	if r.healthFile != "" {
+		r.touchHealth()
+		t := time.NewTicker(r.healthInterval)
+		defer t.Stop()
+		health = t.C
+	}
 
 	for {
 		select {
-		case err := <-done:
-			if err != nil && err.Error() != "signal: killed" {
-				log.Error(err)
+		case fin := <-r.done:
+			if err := r.finished(fin); err != nil {
+				return err
+			}
+		case <-r.timeout():
+			r.cur.log().Warnf("attempt %d timed out after %s", r.cur.attempt, r.retryTimeout)
+			r.cur.timedOut = true
+			r.cur.kill()
+		case <-r.retryDue():
+			next := r.retry
+			r.retry = nil
+			next.log().Infof("retrying command: %s (attempt %d of %d)", next.cmdStr, next.attempt, r.retries+1)
+			if err := r.start(next); err != nil {
+				return err
+			}
+		case c := <-r.control:
+			r.controlled(c)
+		case targets := <-r.targets:
+			r.applyTargets(targets)
+			added, removed, err := r.syncWatches(w)
+			if err != nil {
+				return err
+			}
+			if len(added) > 0 || len(removed) > 0 {
+				log.Infof("targets changed: %d watches added, %d removed", len(added), len(removed))
 			}
 		case <-r.resetTicker.C:
+			if err := r.restoreRoots(w); err != nil {
+				return err
+			}
+
+			for _, e := range r.unpaired() {
+				if err := r.handle(w, e); err != nil {
+					return err
+				}
+			}
+
 			r.mu.Lock()
-			if r.resetNext {
-				log.Infof("running command: %s", r.cmdStr)
+			next := ""
+			var changed []string
+			var since time.Time
+			var op string
+			quiet := r.burstOver()
+			forced := r.forced
+			if r.resetNext && (forced || quiet && r.due() && r.settled() && !r.busy() && !r.strategy.hold(r)) {
+				next, changed, since, op = r.nextCmdStr, r.collapse(r.changed), r.pendingSince, r.lastOp
 				r.resetNext = false
+				r.forced = false
+				r.changed = nil
+				r.lastOp = ""
+				r.eventCount = 0
+			}
+			r.mu.Unlock()
 
-				if cmd != nil && cmd.Process != nil {
-					log.Debug("killing current process")
-					err := cmd.Process.Kill()
-					if err != nil && err.Error() != "os: process already finished" {
-						return err
-					}
-					cmd = nil
+			if next != "" {
+				cur := &run{id: newRunID(), cmdStr: next, changed: changed, op: op, pendingSince: since, attempt: 1}
+				if r.skipRun(cur) {
+					r.startupOver()
+					continue
 				}
-
-				cmd = r.newCmd()
-				if err := cmd.Start(); err != nil {
+				// a forced rerun always starts the command afresh.
+				strategy := r.strategy
+				if forced {
+					strategy = killRestart{}
+				}
+				if err := strategy.restart(r, cur); err != nil {
 					return err
 				}
-				go func() {
-					done <- cmd.Wait()
-				}()
 			}
-			r.mu.Unlock()
-		case e := <-w.Events:
-			if e.Op == fsnotify.Chmod || r.exclude(e.String()) {
-				log.Debugf("skipping %s", e.String())
-			} else {
-				log.Debugf("got event: %s", e.String())
-				r.mu.Lock()
-				r.resetNext = true
-				r.mu.Unlock()
+		case e := <-r.events:
+			for _, e := range r.pair(e) {
+				if err := r.handle(w, e); err != nil {
+					return err
+				}
 			}
 		case err := <-w.Errors:
+			if err != fsnotify.ErrEventOverflow {
+				return &WatchError{Err: err}
+			}
+			if err := r.overflowed(w); err != nil {
+				return err
+			}
+		case <-deadline:
+			r.stop()
+			return &exitError{code: exitMaxDuration, reason: fmt.Sprintf("max duration of %s reached", r.maxDuration)}
+		case <-startup:
+			if err := r.startupCheck("waiting for the first run to finish"); err != nil {
+				r.stop()
+				return err
+			}
+		case <-expect:
+			if !r.sawChange {
+				r.stop()
+				return &exitError{code: exitNoChange, reason: fmt.Sprintf("no change within %s", r.expectWithin)}
+			}
+		case sig := <-interrupt:
+			// a second ctrl-c kills onchange as usual, if the flush takes too long.
+			signal.Stop(interrupt)
+			if r.flushOnExit {
+				return r.flush(sig)
+			}
+			return &exitError{code: exitInterrupted, reason: fmt.Sprintf("got %s", sig)}
+		case <-health:
+			r.touchHealth()
+		case <-liveness:
+			if err := r.checkWatches(w); err != nil {
+				return err
+			}
+		case <-dump:
+			r.dumpState()
+		case sig := <-forceRun:
+			// just like a change, so it waits out the interval and a
+			// running command is restarted the usual way.
+			log.Infof("got %s, running", sig)
+			r.mu.Lock()
+			r.queue(r.cmdStr)
+			r.mu.Unlock()
+		case key := <-keys:
+			if r.pressed(key) {
+				log.Info("quitting")
+				return nil
+			}
+		case <-hup:
+			if err := r.hangup(w); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle processes a single file event.
+func (r *runner) handle(w *fsnotify.Watcher, e fsnotify.Event) error {
+	if r.probed(e.Name) {
+		return nil
+	}
+
+	if r.isIgnoreFile(e.Name) {
+		log.Infof("reloading ignore patterns from %s", e.Name)
+		r.loadIgnores()
+		_, _, err := r.syncWatches(w)
+		return err
+	}
+
+	if r.isMarker(e.Name) {
+		log.Infof("ignore marker %s changed, rescanning", e.Name)
+		_, _, err := r.syncWatches(w)
+		return err
+	}
+
+	if err := r.rootGone(e); err != nil {
+		return err
+	}
+	if r.silentRescan && e.Op&fsnotify.Create != 0 && r.missing[filepath.Clean(e.Name)] {
+		log.Debugf("skipping %s: watch dir is back, rescanning silently", e.String())
+		return nil
+	}
+
+	if e.Op&fsnotify.Create == fsnotify.Create {
+		if err := r.created(w, e.Name); err != nil {
 			return err
 		}
 	}
 
+	if root, _ := r.root(e.Name); root == "" || (e.Op == fsnotify.Chmod && !r.opMapped(fsnotify.Chmod)) || r.exclude(e.Name) || r.excludeDir(e.Name) || r.excludeFile(e.Name) || !r.include(e.Name) || r.selfWritten(e.Name) || !r.relinked(e) || !r.grew(e) || !r.passesFilter(e) {
+		log.Debugf("skipping %s", e.String())
+	} else if cmdStr, ok := r.pluginCommand(e); !ok {
+		log.Debugf("skipping %s: no command for it", e.String())
+	} else {
+		log.Debugf("got event: %s", e.String())
+		r.mu.Lock()
+		r.queue(cmdStr)
+		r.noteChanged(e.Name)
+		r.lastOp = strings.ToLower(e.Op.String())
+		r.sawChange = true
+		r.eventCount++
+		r.track(e.Name)
+		r.noteBurst(e.Name)
+		r.mu.Unlock()
+	}
+
+	if r.emptyCmdStr != "" && r.emptied(e) {
+		log.Info("watch dirs are empty")
+		r.mu.Lock()
+		r.queue(r.emptyCmdStr)
+		r.mu.Unlock()
+	}
+
 	return nil
 }
 
-func (r *runner) newCmd() *exec.Cmd {
-	cmdArgs := strings.Split(r.cmdStr, " ")
-	c := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-	c.Stdout = os.Stdout
-	return c
+// newCmd returns the process for cmdArgs on r's transport, with its output
+// going to the terminal and the command log.
+func (r *runner) newCmd(cmdArgs []string) (*exec.Cmd, error) {
+	c, err := r.transport.command(cmdArgs)
+	if err != nil {
+		return nil, err
+	}
+	c = r.lowerPriority(r.withUmask(c))
+
+	var stdout, stderr io.Writer = r.plain(os.Stdout), r.plain(os.Stderr)
+	if r.cmdLog != nil {
+		w, err := r.cmdLog.writer()
+		if err != nil {
+			return nil, err
+		}
+		logOut := r.plain(w)
+		logErr := logOut
+		if !r.mergeOutput {
+			l := &taggedLog{w: logOut}
+			logOut, logErr = l.stream(stdoutTag), l.stream(stderrTag)
+		}
+		if r.background {
+			stdout, stderr = logOut, logErr
+		} else {
+			stdout = io.MultiWriter(stdout, logOut)
+			stderr = io.MultiWriter(stderr, logErr)
+		}
+	}
+
+	// merged, both streams get the one writer, so exec copies them with a
+	// single goroutine and they can't interleave mid-write.
+	if r.mergeOutput {
+		stderr = stdout
+	}
+	c.Stdout = stdout
+	c.Stderr = stderr
+	if r.adopt {
+		detach(c)
+	}
+	return c, nil
 }
 
 const longDesc = `
@@ -228,17 +1516,3 @@ const longDesc = `
 
 runs a command. when in the given dir changes, kill the old command if it's still running, and then run it again
 `
-
-func (r *runner) exclude(p string) bool {
-	if len(r.ex) < 1 {
-		return false
-	}
-
-	for _, e := range r.ex {
-		if strings.Contains(p, e) {
-			return true
-		}
-	}
-
-	return false
-}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log = logrus.New()
+	log.Out = ioutil.Discard
+	os.Exit(m.Run())
+}
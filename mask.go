@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const masked = "***"
+
+// keyName is what a --mask value looks like when it names a key, rather
+// than being a pattern.
+var keyName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type maskRule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// masker redacts secrets from anything onchange logs.
+type masker []maskRule
+
+// parseMasks compiles --mask values. A bare name like "token" masks the
+// value in token=X, token: X and --token X; anything else is a regexp whose
+// matches are masked whole.
+func parseMasks(vals []string) (masker, error) {
+	var m masker
+	for _, v := range vals {
+		if keyName.MatchString(v) {
+			k := regexp.QuoteMeta(v)
+			re := regexp.MustCompile(`(?i)(\b` + k + `(?:[=:]\s*|\s+))[^\s"'\\]+`)
+			m = append(m, maskRule{re: re, repl: "${1}" + masked})
+			continue
+		}
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("mask: %s", err)
+		}
+		m = append(m, maskRule{re: re, repl: masked})
+	}
+	return m, nil
+}
+
+func (m masker) mask(s string) string {
+	for _, rule := range m {
+		s = rule.re.ReplaceAllString(s, rule.repl)
+	}
+	return s
+}
+
+// Levels and Fire make masker a logrus hook, so messages and string fields
+// are masked before any formatter sees them.
+func (m masker) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (m masker) Fire(e *logrus.Entry) error {
+	e.Message = m.mask(e.Message)
+	// Data is shared with the entry this one came from, so copy it.
+	data := make(logrus.Fields, len(e.Data))
+	for k, v := range e.Data {
+		if s, ok := v.(string); ok {
+			v = m.mask(s)
+		}
+		data[k] = v
+	}
+	e.Data = data
+	return nil
+}
+
+// maskError masks the command in errors that end up printed by cobra
+// rather than logged.
+func (m masker) maskError(err error) error {
+	switch e := err.(type) {
+	case *CommandStartError:
+		e.Command = m.mask(e.Command)
+	case *CommandStopError:
+		e.Command = m.mask(e.Command)
+	}
+	return err
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// matcher decides whether a change counts, from include globs and exclude
+// patterns. Each watch dir has one, so patterns can be written relative to
+// it, and every pattern flag follows the same rules:
+//
+//   - a pattern with no wildcards matches anywhere in the path, as a substring
+//   - a glob with no separator, like "*.go", matches the file name alone
+//   - any other glob matches the path relative to the watch dir, and "**"
+//     stands for any number of directories, including none
+//   - braces list alternatives, so "*.{go,tmpl}" matches either extension
+type matcher struct {
+	// in are the globs a change must match; with none, anything goes.
+	in []string
+
+	// ex are the patterns that rule a change out.
+	ex []string
+}
+
+// excluded reports whether p, which is rel relative to its watch dir,
+// matches an exclude pattern.
+func (m matcher) excluded(p, rel string) bool {
+	return m.excludedBy(p, rel) != ""
+}
+
+// excludedBy returns the exclude pattern that rules p out, or "".
+func (m matcher) excludedBy(p, rel string) string {
+	if pat, ex := lastMatch(m.ex, p, rel); ex {
+		return pat
+	}
+	return ""
+}
+
+// lastMatch goes through the exclude patterns pats in order, returning the
+// last one that matches p, which is rel relative to its watch dir, and
+// whether it excludes p. A pattern starting with "!" is a negation: it takes
+// back what the patterns before it excluded, and a later one can exclude p
+// again. pat is empty if none match.
+func lastMatch(pats []string, p, rel string) (pat string, ex bool) {
+	for _, pt := range pats {
+		neg := strings.HasPrefix(pt, "!")
+		if matchPattern(strings.TrimPrefix(pt, "!"), p, rel) {
+			pat, ex = pt, !neg
+		}
+	}
+	return pat, ex
+}
+
+// included reports whether rel matches an include glob.
+func (m matcher) included(rel string) bool {
+	_, ok := m.includedBy(rel)
+	return ok
+}
+
+// includedBy returns the first include glob rel matches; with none, g is
+// empty and ok is true.
+func (m matcher) includedBy(rel string) (g string, ok bool) {
+	if len(m.in) < 1 {
+		return "", true
+	}
+	for _, g := range m.in {
+		if matchGlob(g, rel) {
+			return g, true
+		}
+	}
+	return "", false
+}
+
+// matchPattern matches an exclude pattern: a substring of p if it has no
+// wildcards, a glob against rel if it has.
+func matchPattern(pat, p, rel string) bool {
+	if !hasWildcard(pat) {
+		return strings.Contains(p, pat)
+	}
+	return matchGlob(pat, rel)
+}
+
+// matchGlob matches g against rel, or against its file name if g has no
+// separator. Each of g's brace alternatives is tried in turn.
+func matchGlob(g, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, alt := range expandBraces(g) {
+		target := rel
+		if !strings.Contains(alt, "/") {
+			target = rel[strings.LastIndex(rel, "/")+1:]
+		}
+		if globMatch(alt, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandBraces expands the first {a,b} in pat into one pattern per
+// alternative, then does the same to each of those, so nested braces work:
+// "{a,b{c,d}}" is a, bc and bd. An alternative can be empty, as in "x{,y}".
+// Braces without a comma, like "{}" or "{a}", unbalanced ones and escaped
+// ones are left as they are.
+func expandBraces(pat string) []string {
+	start, end, alts := braceGroup(pat)
+	if start < 0 {
+		return []string{pat}
+	}
+
+	var out []string
+	for _, alt := range alts {
+		out = append(out, expandBraces(pat[:start]+alt+pat[end+1:])...)
+	}
+	return out
+}
+
+// braceGroup finds the first brace group in pat with a comma at its top
+// level, returning where it opens and closes and its alternatives. start is
+// -1 if there's none.
+func braceGroup(pat string) (start, end int, alts []string) {
+	for i := 0; i < len(pat); i++ {
+		switch pat[i] {
+		case '\\':
+			i++
+		case '{':
+			depth, last := 0, i+1
+			var parts []string
+			for j := i + 1; j < len(pat); j++ {
+				switch pat[j] {
+				case '\\':
+					j++
+				case '{':
+					depth++
+				case '}':
+					if depth > 0 {
+						depth--
+						continue
+					}
+					if parts == nil {
+						// no comma: not a group, but one may be nested in it.
+						j = len(pat)
+						continue
+					}
+					return i, j, append(parts, pat[last:j])
+				case ',':
+					if depth == 0 {
+						parts = append(parts, pat[last:j])
+						last = j + 1
+					}
+				}
+			}
+		}
+	}
+	return -1, -1, nil
+}
+
+// splitPatterns splits a comma-separated list of patterns, leaving the
+// commas inside braces alone.
+func splitPatterns(s string) []string {
+	var out []string
+	depth, last := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				out = append(out, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(out, s[last:])
+}
+
+// globMatch is filepath.Match applied a path segment at a time, with "**"
+// matching any number of segments.
+func globMatch(g, rel string) bool {
+	return matchSegments(strings.Split(g, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(gs, ps []string) bool {
+	for len(gs) > 0 {
+		if gs[0] == "**" {
+			for i := 0; i <= len(ps); i++ {
+				if matchSegments(gs[1:], ps[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(ps) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(gs[0], ps[0]); !ok {
+			return false
+		}
+		gs, ps = gs[1:], ps[1:]
+	}
+	return len(ps) == 0
+}
+
+func hasWildcard(pat string) bool {
+	return strings.ContainsAny(pat, `*?[\{`)
+}
+
+// checkGlob reports a malformed glob, so it fails up front rather than
+// silently matching nothing.
+func checkGlob(g string) error {
+	for _, alt := range expandBraces(g) {
+		for _, seg := range strings.Split(alt, "/") {
+			if _, err := filepath.Match(seg, ""); err != nil {
+				return fmt.Errorf("bad pattern %q", g)
+			}
+		}
+	}
+	return nil
+}
+
+// matcher returns the matcher for the watch dir root; a dir without one of
+// its own, or a path outside every watch dir, gets the global one.
+func (r *runner) matcher(root string) matcher {
+	r.roots.RLock()
+	defer r.roots.RUnlock()
+	if m, ok := r.rootMatch[filepath.Clean(root)]; ok {
+		return m
+	}
+	return r.match
+}
+
+// setMatcher gives the watch dir root a matcher of its own.
+func (r *runner) setMatcher(root string, m matcher) {
+	r.roots.Lock()
+	defer r.roots.Unlock()
+	if r.rootMatch == nil {
+		r.rootMatch = map[string]matcher{}
+	}
+	r.rootMatch[filepath.Clean(root)] = m
+}
+
+// splitGlob splits a glob into the directory before its first wildcard and
+// the rest, so "src/**/*.go" becomes "src" and "**/*.go".
+func splitGlob(pat string) (dir, glob string) {
+	parts := strings.Split(filepath.ToSlash(pat), "/")
+	i := 0
+	for i < len(parts)-1 && !hasWildcard(parts[i]) {
+		i++
+	}
+	dir = strings.Join(parts[:i], "/")
+	if dir == "" && i > 0 {
+		dir = "/"
+	}
+	if dir == "" {
+		dir = "."
+	}
+	return filepath.Clean(filepath.FromSlash(dir)), strings.Join(parts[i:], "/")
+}
+
+// applyPatterns sets up the watch dirs and their matchers from --pattern.
+// Each include pattern's leading directories become a watch dir, and the
+// rest an include glob there. An exclude pattern, starting with "!", applies
+// under the closest watch dir its leading directories are in, or under all
+// of them, relative to each, if it starts with a wildcard or they're in none.
+func (r *runner) applyPatterns(pats []string) error {
+	r.watchDirs = nil
+	r.rootMatch = map[string]matcher{}
+
+	var expanded []string
+	for _, pat := range pats {
+		expanded = append(expanded, expandBraces(pat)...)
+	}
+
+	var excludes []string
+	for _, pat := range expanded {
+		if strings.HasPrefix(pat, "!") {
+			excludes = append(excludes, pat[1:])
+			continue
+		}
+		if err := checkGlob(pat); err != nil {
+			return fmt.Errorf("pattern: %s", err)
+		}
+		if i, err := os.Stat(pat); err == nil && i.IsDir() && !hasWildcard(pat) {
+			pat = strings.TrimSuffix(filepath.ToSlash(pat), "/") + "/**"
+		}
+
+		dir, glob := splitGlob(pat)
+		m, ok := r.rootMatch[dir]
+		if !ok {
+			r.watchDirs = append(r.watchDirs, dir)
+			m.ex = append(m.ex, r.match.ex...)
+		}
+		m.in = append(m.in, glob)
+		r.rootMatch[dir] = m
+	}
+	if len(r.watchDirs) < 1 {
+		return fmt.Errorf("pattern: nothing to watch, only exclude patterns")
+	}
+
+	for _, pat := range excludes {
+		if err := checkGlob(pat); err != nil {
+			return fmt.Errorf("pattern: %s", err)
+		}
+		// patterns here are always root-relative globs; a plain path means
+		// that file or dir and everything under it.
+		if !hasWildcard(pat) {
+			pat = strings.TrimSuffix(filepath.ToSlash(pat), "/") + "/**"
+		}
+
+		dir, _ := splitGlob(pat)
+		root, _ := r.root(dir)
+		if root == "" || dir == "." {
+			for d, m := range r.rootMatch {
+				m.ex = append(m.ex, pat)
+				r.rootMatch[d] = m
+			}
+			continue
+		}
+
+		rel, _ := filepath.Rel(root, filepath.FromSlash(pat))
+		root = filepath.Clean(root)
+		m := r.rootMatch[root]
+		m.ex = append(m.ex, filepath.ToSlash(rel))
+		r.rootMatch[root] = m
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pat, p, rel string
+		want        bool
+	}{
+		// no wildcards: a substring of the path as given.
+		{"vendor", "src/vendor/x.go", "vendor/x.go", true},
+		{"vend", "src/vendor/x.go", "vendor/x.go", true},
+		{".swp", "src/.a.go.swp", "src/.a.go.swp", true},
+		{"vendor", "src/x.go", "src/x.go", false},
+
+		// no separator: the file name alone, at any depth.
+		{"*.go", "src/a/x.go", "a/x.go", true},
+		{"*.go", "x.go", "x.go", true},
+		{"*.go", "src/x.go.orig", "x.go.orig", false},
+		{"x.?o", "src/a/x.go", "a/x.go", true},
+		{"[ab].go", "src/a/b.go", "a/b.go", true},
+		{"a*", "src/a/x.go", "a/x.go", false},
+
+		// a separator: the path relative to the watch dir.
+		{"a/*.go", "src/a/x.go", "a/x.go", true},
+		{"a/*.go", "src/b/a/x.go", "b/a/x.go", false},
+		{"src/*.go", "src/x.go", "x.go", false},
+		{"*/x.go", "src/a/x.go", "a/x.go", true},
+		{"*/x.go", "src/a/b/x.go", "a/b/x.go", false},
+
+		// "**" is any number of directories, including none.
+		{"**/*.go", "src/x.go", "x.go", true},
+		{"**/*.go", "src/a/b/c/x.go", "a/b/c/x.go", true},
+		{"a/**/x.go", "src/a/x.go", "a/x.go", true},
+		{"a/**/x.go", "src/a/b/c/x.go", "a/b/c/x.go", true},
+		{"a/**/x.go", "src/b/x.go", "b/x.go", false},
+		{"a/**", "src/a/b/x.go", "a/b/x.go", true},
+		{"a/**", "src/ab/x.go", "ab/x.go", false},
+		{"**/gen/**", "src/a/gen/b/x.go", "a/gen/b/x.go", true},
+		{"**/gen/**", "src/a/generated/x.go", "a/generated/x.go", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pat, tt.p, tt.rel); got != tt.want {
+			t.Errorf("matchPattern(%q, %q, %q) = %v, want %v", tt.pat, tt.p, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestMatcherPerRoot(t *testing.T) {
+	r := &runner{
+		watchDirs: []string{"src", "src/web", "docs"},
+		match:     matcher{in: []string{"*.go"}, ex: []string{"gen/**"}},
+		rootMatch: map[string]matcher{
+			"src/web": {in: []string{"*.js", "static/**"}, ex: []string{"*.min.js"}},
+		},
+	}
+
+	tests := []struct {
+		p                 string
+		root, rel         string
+		included, exclude bool
+	}{
+		// the closest watch dir wins, so src/web has its own rel and matcher.
+		{"src/x.go", "src", "x.go", true, false},
+		{"src/web/x.go", "src/web", "x.go", false, false},
+		{"src/web/app.js", "src/web", "app.js", true, false},
+		{"src/web/app.min.js", "src/web", "app.min.js", true, true},
+		{"src/web/static/img/a.png", "src/web", "static/img/a.png", true, false},
+
+		// globs with a separator are relative to the watch dir, not the cwd.
+		{"src/gen/x.go", "src", "gen/x.go", true, true},
+		{"src/a/gen/x.go", "src", "a/gen/x.go", true, false},
+		{"docs/gen/x.go", "docs", "gen/x.go", true, true},
+
+		// outside every watch dir.
+		{"other/x.go", "", "", true, false},
+	}
+	for _, tt := range tests {
+		root, rel := r.root(tt.p)
+		if root != tt.root || rel != tt.rel {
+			t.Errorf("root(%q) = %q, %q, want %q, %q", tt.p, root, rel, tt.root, tt.rel)
+		}
+		if got := r.include(tt.p); got != tt.included {
+			t.Errorf("include(%q) = %v, want %v", tt.p, got, tt.included)
+		}
+		if got := r.exclude(tt.p); got != tt.exclude {
+			t.Errorf("exclude(%q) = %v, want %v", tt.p, got, tt.exclude)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	tests := []struct {
+		in, ex, ignored []string
+		p               string
+		want            bool
+	}{
+		// the README's table.
+		{[]string{"*.go", "*.log"}, []string{"*_test.go", "!keep_test.go", "*.log"}, nil, "a.go", true},
+		{[]string{"*.go", "*.log"}, []string{"*_test.go", "!keep_test.go", "*.log"}, nil, "a_test.go", false},
+		{[]string{"*.go", "*.log"}, []string{"*_test.go", "!keep_test.go", "*.log"}, nil, "keep_test.go", true},
+		{[]string{"*.go", "*.log"}, []string{"*_test.go", "!keep_test.go", "*.log"}, nil, "b.log", false},
+		{[]string{"*.go", "*.log"}, []string{"*_test.go", "!keep_test.go", "*.log"}, []string{"!b.log"}, "b.log", true},
+		{[]string{"*.go", "*.log"}, []string{"*_test.go", "!keep_test.go", "*.log"}, nil, "a.txt", false},
+
+		// include × exclude, without negation: exclude wins, include gates.
+		{nil, nil, nil, "a.go", true},
+		{nil, []string{"*.go"}, nil, "a.go", false},
+		{[]string{"*.go"}, nil, nil, "a.go", true},
+		{[]string{"*.go"}, []string{"*.go"}, nil, "a.go", false},
+		{[]string{"*.txt"}, nil, nil, "a.go", false},
+		{[]string{"*.txt"}, []string{"*.go"}, nil, "a.go", false},
+
+		// a negation alone excludes nothing, and can't get past include.
+		{nil, []string{"!a.go"}, nil, "a.go", true},
+		{[]string{"*.txt"}, []string{"*.go", "!a.go"}, nil, "a.go", false},
+
+		// the last match wins, so order matters.
+		{nil, []string{"*.go", "!a.go"}, nil, "a.go", true},
+		{nil, []string{"!a.go", "*.go"}, nil, "a.go", false},
+		{nil, []string{"*.go", "!a.go", "a.*"}, nil, "a.go", false},
+		{nil, []string{"*.go", "!a.go", "a.*", "!*.go"}, nil, "a.go", true},
+		{nil, []string{"*.go", "!b.go"}, nil, "a.go", false},
+
+		// the ignore files come after the exclude patterns.
+		{nil, []string{"*.go"}, []string{"!a.go"}, "a.go", true},
+		{nil, []string{"!a.go"}, []string{"*.go"}, "a.go", false},
+		{nil, nil, []string{"*.go", "!a.go"}, "a.go", true},
+		{nil, nil, []string{"!a.go", "*.go"}, "a.go", false},
+		{nil, []string{"*.go"}, []string{"!b.go"}, "a.go", false},
+	}
+	for _, tt := range tests {
+		r := &runner{
+			watchDirs: []string{"."},
+			match:     matcher{in: tt.in, ex: tt.ex},
+		}
+		r.ignored.Store(tt.ignored)
+		got := !r.exclude(tt.p) && r.include(tt.p)
+		if got != tt.want {
+			t.Errorf("in %q, ex %q, ignored %q: %s counts = %v, want %v", tt.in, tt.ex, tt.ignored, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestExcludeFilePrecedence(t *testing.T) {
+	tests := []struct {
+		exFiles []string
+		p       string
+		want    string
+	}{
+		{nil, "src/a.go", ""},
+		{[]string{"*.go"}, "src/a.go", "*.go"},
+		{[]string{"*.go", "!a.go"}, "src/a.go", ""},
+		{[]string{"!a.go", "*.go"}, "src/a.go", "*.go"},
+		{[]string{"*.go", "!a.go"}, "src/b.go", "*.go"},
+	}
+	for _, tt := range tests {
+		r := &runner{exFiles: tt.exFiles}
+		if got := r.excludeFileBy(tt.p); got != tt.want {
+			t.Errorf("exFiles %q: excludeFileBy(%q) = %q, want %q", tt.exFiles, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		pat  string
+		want []string
+	}{
+		{"*.go", []string{"*.go"}},
+		{"*.{go,js}", []string{"*.go", "*.js"}},
+		{"{a,b}/{c,d}", []string{"a/c", "a/d", "b/c", "b/d"}},
+
+		// nested groups.
+		{"{a,b{c,d}}", []string{"a", "bc", "bd"}},
+		{"{a,{b,c}}", []string{"a", "b", "c"}},
+		{"{{a,b}}", []string{"{a}", "{b}"}},
+		{"{a}{b,c}", []string{"{a}b", "{a}c"}},
+
+		// empty alternatives.
+		{"x{,y}", []string{"x", "xy"}},
+		{"x{y,}", []string{"xy", "x"}},
+		{"{,}", []string{"", ""}},
+
+		// no comma: not a group.
+		{"{}", []string{"{}"}},
+		{"{a}", []string{"{a}"}},
+		{"x{}y", []string{"x{}y"}},
+
+		// unbalanced.
+		{"{a,b", []string{"{a,b"}},
+		{"a,b}", []string{"a,b}"}},
+		{"{a,b}}", []string{"a}", "b}"}},
+
+		// escaped.
+		{`\{a,b}`, []string{`\{a,b}`}},
+		{`{a,b\}`, []string{`{a,b\}`}},
+		{`{a\,b,c}`, []string{`a\,b`, "c"}},
+	}
+	for _, tt := range tests {
+		if got := expandBraces(tt.pat); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandBraces(%q) = %q, want %q", tt.pat, got, tt.want)
+		}
+	}
+}
+
+func TestBraceGroup(t *testing.T) {
+	tests := []struct {
+		pat        string
+		start, end int
+		alts       []string
+	}{
+		{"*.go", -1, -1, nil},
+		{"*.{go,js}", 2, 8, []string{"go", "js"}},
+		{"{a,b{c,d}}", 0, 9, []string{"a", "b{c,d}"}},
+		{"{a}{b,c}", 3, 7, []string{"b", "c"}},
+		{"x{,y}", 1, 4, []string{"", "y"}},
+		{"{}", -1, -1, nil},
+		{"{a}", -1, -1, nil},
+		{"{a,b", -1, -1, nil},
+		{`\{a,b}`, -1, -1, nil},
+	}
+	for _, tt := range tests {
+		start, end, alts := braceGroup(tt.pat)
+		if start != tt.start || end != tt.end || !reflect.DeepEqual(alts, tt.alts) {
+			t.Errorf("braceGroup(%q) = %d, %d, %q, want %d, %d, %q", tt.pat, start, end, alts, tt.start, tt.end, tt.alts)
+		}
+	}
+}
+
+func TestSplitPatterns(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", []string{""}},
+		{"*.go", []string{"*.go"}},
+		{"*.go,*.js", []string{"*.go", "*.js"}},
+		{"a,,b", []string{"a", "", "b"}},
+		{"*.{go,js},x", []string{"*.{go,js}", "x"}},
+		{"{a,{b,c}},d", []string{"{a,{b,c}}", "d"}},
+		{"{},a", []string{"{}", "a"}},
+		{"{a,b", []string{"{a,b"}},
+		{"a},b", []string{"a}", "b"}},
+		{`a\,b,c`, []string{`a\,b`, "c"}},
+		{`\{a,b}`, []string{`\{a`, "b}"}},
+	}
+	for _, tt := range tests {
+		if got := splitPatterns(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitPatterns(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
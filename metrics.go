@@ -0,0 +1,74 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the run latency histogram.
+var latencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// histogram counts durations into latencyBuckets, cumulatively like
+// prometheus does, with everything counted under "+Inf".
+type histogram struct {
+	mu     sync.Mutex
+	counts []int64
+	count  int64
+	sum    time.Duration
+}
+
+func (h *histogram) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.counts == nil {
+		h.counts = make([]int64, len(latencyBuckets))
+	}
+	for i, b := range latencyBuckets {
+		if d <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += d
+}
+
+// value is the histogram as served on /debug/vars.
+func (h *histogram) value() interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buckets := map[string]int64{"+Inf": h.count}
+	for i, b := range latencyBuckets {
+		var n int64
+		if h.counts != nil {
+			n = h.counts[i]
+		}
+		buckets[fmt.Sprintf("%g", b.Seconds())] = n
+	}
+	return map[string]interface{}{
+		"buckets":     buckets,
+		"count":       h.count,
+		"sum_seconds": h.sum.Seconds(),
+	}
+}
+
+// runLatency is the time from the first change of a batch to its command
+// starting.
+var runLatency = &histogram{}
+
+func init() {
+	expvar.Publish("run_latency", expvar.Func(runLatency.value))
+}
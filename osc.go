@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// oscNotify sends the terminal a desktop notification for how fin ended,
+// with the OSC 9 escape sequence iTerm2 and others turn into one. It's only
+// written when stderr is a terminal, so it never ends up in a log file.
+func (r *runner) oscNotify(fin *run) {
+	if !isTerminal(int(os.Stderr.Fd())) {
+		return
+	}
+
+	msg := fmt.Sprintf("onchange: %s succeeded", fin.cmdStr)
+	if fin.err != nil {
+		msg = fmt.Sprintf("onchange: %s failed (exit code %d)", fin.cmdStr, fin.exitCode())
+	}
+	// a stray control character would end the sequence early.
+	msg = strings.Map(func(c rune) rune {
+		if c < ' ' || c == 0x7f {
+			return ' '
+		}
+		return c
+	}, r.masks.mask(msg))
+	fmt.Fprintf(os.Stderr, "\x1b]9;%s\x07", msg)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// commandLog copies the command's output to a file, in addition to the
+// terminal.
+type commandLog struct {
+	// path is the file to write to.
+	path string
+
+	// append keeps whatever was in the file at startup instead of truncating it.
+	append bool
+
+	// rotate starts a fresh file for every run, moving the previous run's
+	// output to path + ".1".
+	rotate bool
+
+	f *os.File
+}
+
+// writer returns the file to write the next run's output to, rotating it
+// first if asked to.
+func (l *commandLog) writer() (io.Writer, error) {
+	if l.f != nil && !l.rotate {
+		return l.f, nil
+	}
+
+	if l.f != nil {
+		l.f.Close()
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return nil, err
+		}
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if l.append && l.f == nil {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(l.path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.f = f
+	return f, nil
+}
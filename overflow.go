@@ -0,0 +1,26 @@
+package main
+
+import "github.com/fsnotify/fsnotify"
+
+// overflowed recovers from the kernel dropping events under load. Whatever
+// happened in the gap is unknown, so the watches are synced, to pick up
+// directories created in it, and the command runs once to catch up, unless
+// rescans are silent.
+func (r *runner) overflowed(w *fsnotify.Watcher) error {
+	log.Warn("file events were dropped under load, rescanning the watch dirs")
+	added, removed, err := r.syncWatches(w)
+	if err != nil {
+		return err
+	}
+	if r.silentRescan {
+		log.Infof("rescanned watches: %d added, %d removed", len(added), len(removed))
+		return nil
+	}
+	log.Infof("rescanned watches: %d added, %d removed; running to catch up", len(added), len(removed))
+
+	r.mu.Lock()
+	r.queue(r.cmdStr)
+	r.sawChange = true
+	r.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// pipeTo adds the --pipe-to stages to cur, each reading the previous one's
+// stdout, like a shell pipeline. The last stage gets the output cur.cmd was
+// set up with; every stage shares its stderr and environment.
+func (r *runner) pipeTo(cur *run) error {
+	if len(r.pipeCmdStrs) == 0 {
+		return nil
+	}
+
+	out := cur.cmd.Stdout
+	prev := cur.cmd
+	for _, s := range r.pipeCmdStrs {
+		args, err := splitCommand(s, r.quoteStyle)
+		if err != nil {
+			return err
+		}
+		stage, err := r.transport.command(args)
+		if err != nil {
+			return err
+		}
+		stage = r.lowerPriority(r.withUmask(stage))
+		stage.Env = cur.cmd.Env
+		stage.Stderr = cur.cmd.Stderr
+
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		prev.Stdout = pw
+		if r.mergeOutput {
+			prev.Stderr = pw
+		}
+		stage.Stdin = pr
+		cur.pipeFiles = append(cur.pipeFiles, pr, pw)
+
+		cur.pipe = append(cur.pipe, stage)
+		prev = stage
+	}
+	prev.Stdout = out
+	return nil
+}
+
+// stages returns every process of the run: the command, then each
+// --pipe-to stage.
+func (cur *run) stages() []*exec.Cmd {
+	return append([]*exec.Cmd{cur.cmd}, cur.pipe...)
+}
+
+// startStages starts every stage of cur. If one can't start, the ones
+// before it are killed.
+func (r *runner) startStages(cur *run) error {
+	defer cur.closePipes()
+	for i, c := range cur.stages() {
+		if err := c.Start(); err != nil {
+			for _, started := range cur.stages()[:i] {
+				started.Process.Kill()
+				started.Wait()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// closePipes closes onchange's copies of the pipes between stages, so each
+// stage sees EOF once the one before it exits.
+func (cur *run) closePipes() {
+	for _, f := range cur.pipeFiles {
+		f.Close()
+	}
+	cur.pipeFiles = nil
+}
+
+// wait waits for every stage of cur to exit. When one fails, the rest are
+// killed rather than left blocked on a pipe, and its error is the run's.
+func (cur *run) wait() error {
+	if len(cur.pipe) == 0 {
+		return cur.cmd.Wait()
+	}
+
+	type exit struct {
+		n   int
+		err error
+	}
+	stages := cur.stages()
+	exits := make(chan exit, len(stages))
+	for i, c := range stages {
+		go func(n int, c *exec.Cmd) {
+			exits <- exit{n, c.Wait()}
+		}(i, c)
+	}
+
+	var first error
+	for range stages {
+		e := <-exits
+		if e.err == nil || first != nil {
+			continue
+		}
+		first = e.err
+		cur.failed = stages[e.n]
+		if e.n < len(stages)-1 {
+			cur.log().Warnf("pipeline stage %d of %d failed, stopping the rest: %s", e.n+1, len(stages), e.err)
+		}
+		cur.kill()
+	}
+	return first
+}
+
+// kill kills every stage of cur.
+func (cur *run) kill() error {
+	var first error
+	for _, c := range cur.stages() {
+		if c.Process == nil {
+			continue
+		}
+		if err := c.Process.Kill(); err != nil && err.Error() != "os: process already finished" && first == nil {
+			first = err
+		}
+	}
+	return first
+}
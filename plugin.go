@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pluginHandler is what a --plugin hands back from its NewHandler. Plugins
+// can't import package main, so the contract is made of builtin types only:
+// Handle gets each change that got through the filters, as the path and an
+// op like "WRITE" or "CREATE|CHMOD", and returns whether to run and, if not
+// empty, the command to run instead of the one onchange would pick.
+type pluginHandler interface {
+	Handle(path, op string) (run bool, command string)
+}
+
+// pluginAPI is the contract version; a plugin can export
+//
+//	var OnchangeAPI = 1
+//
+// to be refused by onchange versions with a different contract.
+const pluginAPI = 1
+
+// loadPlugin opens the plugin at path and gets its handler from
+//
+//	func NewHandler() interface{}
+func loadPlugin(path string) (pluginHandler, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if sym, err := p.Lookup("OnchangeAPI"); err == nil {
+		v, ok := sym.(*int)
+		if !ok {
+			return nil, fmt.Errorf("plugin %s: OnchangeAPI must be an int, got %T", path, sym)
+		}
+		if *v != pluginAPI {
+			return nil, fmt.Errorf("plugin %s: built for plugin API %d, this onchange has %d", path, *v, pluginAPI)
+		}
+	}
+
+	sym, err := p.Lookup("NewHandler")
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %s", path, err)
+	}
+	newHandler, ok := sym.(func() interface{})
+	if !ok {
+		return nil, fmt.Errorf("plugin %s: NewHandler must be a func() interface{}, got %T", path, sym)
+	}
+	h, ok := newHandler().(pluginHandler)
+	if !ok {
+		return nil, fmt.Errorf("plugin %s: NewHandler's result has no Handle(path, op string) (bool, string) method", path)
+	}
+	return h, nil
+}
+
+// pluginCommand picks the command e should run, asking the plugin, if
+// there is one, whether it should run at all.
+func (r *runner) pluginCommand(e fsnotify.Event) (string, bool) {
+	cmdStr, ok := r.commandFor(e)
+	if r.plugin == nil || !ok {
+		return cmdStr, ok
+	}
+
+	run, c := r.plugin.Handle(e.Name, e.Op.String())
+	if c != "" {
+		cmdStr = c
+	}
+	return cmdStr, run
+}
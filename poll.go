@@ -0,0 +1,259 @@
+package main
+
+import (
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileState is what the poller remembers about a path between scans. sum is
+// a checksum of a file's contents, with --poll-hash only.
+type fileState struct {
+	dir     bool
+	size    int64
+	modTime time.Time
+	sum     uint32
+}
+
+// castagnoli is the crc32 table --poll-hash checksums with; most CPUs have
+// instructions for it.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum returns a checksum of the file at p's contents, or 0 if it can't
+// be read; a file that can't be read now will be noticed when it can.
+func checksum(p string) uint32 {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	h := crc32.New(castagnoli)
+	if _, err := io.Copy(h, f); err != nil {
+		return 0
+	}
+	return h.Sum32()
+}
+
+// snapshot scans dir and returns the state of everything under it that isn't
+// excluded, down to the depth limit.
+func (r *runner) snapshot(dir string) map[string]fileState {
+	snap := map[string]fileState{}
+	filepath.Walk(dir, func(p string, i os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if i.IsDir() && r.excludeDir(p) {
+			return filepath.SkipDir
+		}
+		if r.exclude(p) {
+			return nil
+		}
+		s := fileState{dir: i.IsDir(), size: i.Size(), modTime: i.ModTime()}
+		if r.pollHash && i.Mode().IsRegular() {
+			s.sum = checksum(p)
+		}
+		snap[p] = s
+		if i.IsDir() && r.tooDeep(p) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return snap
+}
+
+// idleScans is how many scans in a row must find nothing before an adaptive
+// poll interval backs off.
+const idleScans = 3
+
+// focusScans is how many focused scans in a row must find nothing in a
+// directory before --poll-focus stops focusing on it.
+const focusScans = 10
+
+// poll watches dir by scanning it every pollInterval and comparing snapshots,
+// for filesystems that don't deliver change notifications. Differences are
+// sent down the same pipeline as fsnotify's events.
+//
+// With pollMin and pollMax set, the interval adapts: it doubles, up to
+// pollMax, after idleScans quiet scans, and drops to pollMin as soon as a
+// scan finds a change.
+//
+// With pollFocus set, the whole of dir is only scanned every pollFocus
+// intervals. In between, just the directories where changes were found
+// lately are, each until focusScans scans of it in a row find nothing.
+func (r *runner) poll(dir string) {
+	interval := r.pollInterval
+	adaptive := r.pollMin > 0 && r.pollMax > 0
+	if adaptive {
+		interval = clamp(interval, r.pollMin, r.pollMax)
+	}
+
+	log.Debugf("polling %s every %s", dir, interval)
+	prev := r.snapshot(dir)
+	idle := 0
+	hot := map[string]int{}
+
+	for tick := 1; ; tick++ {
+		time.Sleep(interval)
+		if r.pollFocus > 1 && tick%r.pollFocus != 0 {
+			r.pollHot(dir, prev, hot)
+			continue
+		}
+		cur := r.snapshot(dir)
+
+		// a dir that was gone and is back is rescanned from scratch, with
+		// everything in it new; with --silent-rescan, that's only a baseline.
+		if _, back := cur[dir]; back && r.silentRescan {
+			if _, was := prev[dir]; !was {
+				log.Infof("%s is back, taking its files as they are", dir)
+				prev = cur
+				continue
+			}
+		}
+
+		changed := r.compare(prev, cur, "")
+		if r.pollFocus > 1 {
+			focus(dir, hot, changed)
+		}
+
+		prev = cur
+		if !adaptive {
+			continue
+		}
+
+		next := interval
+		if len(changed) > 0 {
+			idle = 0
+			next = r.pollMin
+		} else if idle++; idle >= idleScans {
+			idle = 0
+			next = clamp(interval*2, r.pollMin, r.pollMax)
+		}
+		if next != interval {
+			log.Debugf("polling %s every %s", dir, next)
+			interval = next
+		}
+	}
+}
+
+// compare sends events for the differences between prev and cur, both
+// snapshots of under, or of the whole poll dir if under is "", and returns
+// the paths that changed.
+func (r *runner) compare(prev, cur map[string]fileState, under string) []string {
+	var changed []string
+	for p, s := range cur {
+		old, ok := prev[p]
+		switch {
+		case !ok:
+			r.events <- fsnotify.Event{Name: p, Op: fsnotify.Create}
+			changed = append(changed, p)
+		case !s.dir && (s.size != old.size || !s.modTime.Equal(old.modTime) || s.sum != old.sum):
+			r.events <- fsnotify.Event{Name: p, Op: fsnotify.Write}
+			changed = append(changed, p)
+		}
+	}
+
+	for p := range prev {
+		if under != "" && !within(under, p) {
+			continue
+		}
+		if _, ok := cur[p]; !ok {
+			r.events <- fsnotify.Event{Name: p, Op: fsnotify.Remove}
+			changed = append(changed, p)
+		}
+	}
+	return changed
+}
+
+// pollHot scans just the hot directories under dir, updating prev, the
+// snapshot of all of dir, with what it finds. A hot directory inside
+// another is covered by that one's scan.
+func (r *runner) pollHot(dir string, prev map[string]fileState, hot map[string]int) {
+	for d := range hot {
+		if coveredBy(d, hot) {
+			delete(hot, d)
+			continue
+		}
+
+		cur := r.snapshot(d)
+		changed := r.compare(prev, cur, d)
+		for p := range prev {
+			if within(d, p) {
+				delete(prev, p)
+			}
+		}
+		for p, s := range cur {
+			prev[p] = s
+		}
+
+		if len(changed) > 0 {
+			focus(dir, hot, changed)
+		} else if hot[d]--; hot[d] <= 0 {
+			log.Debugf("no longer focusing on %s", d)
+			delete(hot, d)
+		}
+	}
+}
+
+// focus marks the directories the changed paths are in as hot, for the next
+// focusScans scans. The poll dir itself is never hot; it's what full scans
+// are for.
+func focus(dir string, hot map[string]int, changed []string) {
+	for _, p := range changed {
+		d := filepath.Dir(p)
+		if d == dir || !within(dir, d) {
+			continue
+		}
+		if _, ok := hot[d]; !ok {
+			log.Debugf("focusing on %s", d)
+		}
+		hot[d] = focusScans
+	}
+}
+
+// coveredBy reports whether d is inside another of the hot directories.
+func coveredBy(d string, hot map[string]int) bool {
+	for h := range hot {
+		if h != d && within(h, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether p is dir or under it.
+func within(dir, p string) bool {
+	rp, err := filepath.Rel(dir, p)
+	if err != nil {
+		return false
+	}
+	rp = filepath.ToSlash(rp)
+	return rp != ".." && !strings.HasPrefix(rp, "../")
+}
+
+// clamp limits d to the range [min, max].
+func clamp(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// polled reports whether p is under a directory that's being polled rather
+// than watched with fsnotify.
+func (r *runner) polled(p string) bool {
+	for _, dir := range r.pollDirs {
+		if within(dir, p) {
+			return true
+		}
+	}
+	return false
+}
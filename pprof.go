@@ -0,0 +1,15 @@
+package main
+
+import (
+	"net/http"
+	_ "net/http/pprof"
+)
+
+// servePprof exposes the net/http/pprof endpoints on addr, for profiling
+// onchange itself.
+func servePprof(addr string) {
+	log.Infof("serving pprof on http://%s/debug/pprof/", addr)
+	if err := http.ListenAndServe(addr, nil); err != nil {
+		log.Errorf("pprof: %s", err)
+	}
+}
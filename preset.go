@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// presets are flag defaults for common stacks, keyed by --preset name.
+var presets = map[string]map[string]string{
+	"go": {
+		"include": "*.go,go.mod,go.sum",
+		"exclude": "vendor/",
+	},
+	"node": {
+		"include": "*.js,*.jsx,*.ts,*.tsx,*.json",
+		"exclude": "dist/,build/,coverage/",
+	},
+	"python": {
+		"include": "*.py,*.cfg,*.toml",
+		"exclude": "__pycache__/,.venv/,.pyc,.tox/",
+	},
+}
+
+// presetNames lists the known presets for help and errors.
+func presetNames() string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyPreset sets the flags of the chosen preset, leaving alone any flag
+// given on the command line.
+func applyPreset(c *cobra.Command) error {
+	name, _ := c.Flags().GetString("preset")
+	if name == "" {
+		return nil
+	}
+
+	preset, ok := presets[name]
+	if !ok {
+		return fmt.Errorf("unknown preset: %s (one of %s)", name, presetNames())
+	}
+
+	for flag, value := range preset {
+		if c.Flags().Changed(flag) {
+			continue
+		}
+		if err := c.Flags().Set(flag, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ioClass is an io scheduling class, numbered as in linux/ioprio.h and as
+// ionice -c takes it.
+type ioClass int
+
+const (
+	ioClassBestEffort ioClass = 2
+	ioClassIdle       ioClass = 3
+)
+
+// parseIonice parses an --ionice value: "idle", or "best-effort" with an
+// optional level from 0 (highest) to 7 (lowest), like "best-effort:7".
+func parseIonice(s string) (ioClass, int, error) {
+	name, levelStr := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		name, levelStr = s[:i], s[i+1:]
+	}
+
+	switch name {
+	case "idle":
+		if levelStr != "" {
+			return 0, 0, fmt.Errorf("ionice class idle takes no level: %s", s)
+		}
+		return ioClassIdle, 0, nil
+	case "best-effort":
+		if levelStr == "" {
+			return ioClassBestEffort, 4, nil
+		}
+		level, err := strconv.Atoi(levelStr)
+		if err != nil || level < 0 || level > 7 {
+			return 0, 0, fmt.Errorf("invalid ionice level: %s", levelStr)
+		}
+		return ioClassBestEffort, level, nil
+	}
+	return 0, 0, fmt.Errorf("unknown ionice class: %s", name)
+}
+
+// checkPriority drops --nice or --ionice, with a warning, when the nice or
+// ionice command that applies it isn't installed, so the command runs as
+// usual without it.
+func (r *runner) checkPriority() {
+	if r.nice != 0 {
+		if _, err := exec.LookPath("nice"); err != nil {
+			log.Warnf("can't set nice %d: %s", r.nice, err)
+			r.nice = 0
+		}
+	}
+	if r.ionice != "" {
+		if _, err := exec.LookPath("ionice"); err != nil {
+			log.Warnf("can't set ionice %s: %s", r.ionice, err)
+			r.ionice = ""
+		}
+	}
+}
+
+// priorityArgs returns the nice and ionice invocation that runs a command at
+// the --nice and --ionice priority, or nil for neither. ionice is told to run
+// the command even if it can't set the class, as nice does for a level it
+// isn't allowed.
+func (r *runner) priorityArgs() []string {
+	var args []string
+	if r.nice != 0 {
+		args = append(args, "nice", "-n", strconv.Itoa(r.nice))
+	}
+	if r.ionice != "" {
+		class, level, _ := parseIonice(r.ionice)
+		args = append(args, "ionice", "-t", "-c", strconv.Itoa(int(class)))
+		if class == ioClassBestEffort {
+			args = append(args, "-n", strconv.Itoa(level))
+		}
+	}
+	return args
+}
+
+// lowerPriority returns c run under nice and ionice, so the command starts at
+// the lower priority instead of being lowered once it's running, when it may
+// already have forked. c must not have been started or set up yet. A command
+// that can't be found is left as is, for Start to report.
+func (r *runner) lowerPriority(c *exec.Cmd) *exec.Cmd {
+	args := r.priorityArgs()
+	if len(args) == 0 {
+		return c
+	}
+	if _, err := exec.LookPath(c.Path); err != nil {
+		return c
+	}
+	args = append(args, c.Args...)
+	return exec.Command(args[0], args[1:]...)
+}
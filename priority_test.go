@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIonice(t *testing.T) {
+	tests := []struct {
+		s     string
+		class ioClass
+		level int
+		err   bool
+	}{
+		{"idle", ioClassIdle, 0, false},
+		{"best-effort", ioClassBestEffort, 4, false},
+		{"best-effort:0", ioClassBestEffort, 0, false},
+		{"best-effort:7", ioClassBestEffort, 7, false},
+		{"best-effort:8", 0, 0, true},
+		{"best-effort:x", 0, 0, true},
+		{"idle:3", 0, 0, true},
+		{"realtime", 0, 0, true},
+	}
+	for _, tt := range tests {
+		class, level, err := parseIonice(tt.s)
+		if (err != nil) != tt.err || class != tt.class || level != tt.level {
+			t.Errorf("parseIonice(%q) = %d, %d, %v, want %d, %d, error %v", tt.s, class, level, err, tt.class, tt.level, tt.err)
+		}
+	}
+}
+
+func TestLowerPriority(t *testing.T) {
+	tests := []struct {
+		nice   int
+		ionice string
+		want   []string
+	}{
+		{0, "", []string{"sh", "-c", "true"}},
+		{10, "", []string{"nice", "-n", "10", "sh", "-c", "true"}},
+		{0, "idle", []string{"ionice", "-t", "-c", "3", "sh", "-c", "true"}},
+		{5, "best-effort:7", []string{"nice", "-n", "5", "ionice", "-t", "-c", "2", "-n", "7", "sh", "-c", "true"}},
+	}
+	for _, tt := range tests {
+		r := &runner{nice: tt.nice, ionice: tt.ionice}
+		c, _ := localTransport{}.command([]string{"sh", "-c", "true"})
+		if got := r.lowerPriority(c).Args; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("--nice %d --ionice %q: argv = %q, want %q", tt.nice, tt.ionice, got, tt.want)
+		}
+	}
+
+	// a command that isn't there is left for Start to report.
+	r := &runner{nice: 10}
+	c, _ := localTransport{}.command([]string{"onchange-no-such-command"})
+	if got := r.lowerPriority(c); got != c {
+		t.Errorf("missing command: argv = %q, want it left as is", got.Args)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// probePrefix names the files the startup probe creates. They're excluded by
+// default, so they never trigger a run.
+const probePrefix = ".onchange-probe"
+
+// probeTimeout is how long the probe waits to hear about its own file.
+const probeTimeout = 2 * time.Second
+
+// probe checks that fsnotify actually reports changes in dir, by creating and
+// removing a file there and waiting for the event. Some filesystems, like
+// certain FUSE and network mounts, accept watches but never deliver events.
+// If dir can't be written to there's no way to tell, and the probe passes.
+func probe(dir string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := w.Add(dir); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, probePrefix)
+	if err != nil {
+		log.Debugf("can't probe %s: %s", dir, err)
+		return nil
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	timeout := time.After(probeTimeout)
+	for {
+		select {
+		case e := <-w.Events:
+			if strings.HasPrefix(filepath.Base(e.Name), probePrefix) {
+				return nil
+			}
+		case err := <-w.Errors:
+			return err
+		case <-timeout:
+			return fmt.Errorf("no file events from %s after %s", dir, probeTimeout)
+		}
+	}
+}
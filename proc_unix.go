@@ -0,0 +1,67 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// detach starts c in a session of its own, so it outlives onchange.
+func detach(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// alive reports whether pid is a running process.
+func alive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+// killSession kills every process in the session led by pid.
+func killSession(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
+
+// promoteSignal tells a --warm-command spare to take over.
+func promoteSignal(pid int) error {
+	return syscall.Kill(pid, syscall.SIGUSR1)
+}
+
+// reloadSignal asks the command to reload, for --restart-strategy signal.
+func reloadSignal(pid int) error {
+	return syscall.Kill(pid, syscall.SIGHUP)
+}
+
+// umaskArgs returns the sh invocation that sets mask and then execs the
+// command after it, so only the command gets the umask.
+func umaskArgs(mask int) []string {
+	return []string{"sh", "-c", fmt.Sprintf(`umask %04o && exec "$@"`, mask), "sh"}
+}
+
+// dumpSignal asks onchange to log its state, with --dump-state.
+var dumpSignal os.Signal = syscall.SIGUSR2
+
+// runSignals are the signals --run-on-signal takes. SIGHUP isn't one, as
+// onchange reloads on it.
+var runSignals = map[string]os.Signal{
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// runSignal returns the signal named name, like USR1 or SIGUSR1, for
+// --run-on-signal.
+func runSignal(name string) (os.Signal, error) {
+	short := strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	if sig, ok := runSignals[short]; ok {
+		return sig, nil
+	}
+	if short == "HUP" {
+		return nil, errors.New("--run-on-signal can't be HUP: onchange already reloads on SIGHUP")
+	}
+	return nil, fmt.Errorf("unknown run signal %q: expected USR1 or USR2", name)
+}
@@ -0,0 +1,105 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRunStopsCommand starts a long-running command, makes Run return, and
+// checks the command is gone.
+func TestRunStopsCommand(t *testing.T) {
+	osExit = func(int) {}
+	defer func() { osExit = os.Exit }()
+
+	tests := []struct {
+		name   string
+		flags  []string
+		cancel func()
+	}{
+		{"max duration", []string{"--max-duration", "3s"}, func() {}},
+		{"SIGTERM", []string{"--max-duration", "0"}, func() { syscall.Kill(os.Getpid(), syscall.SIGTERM) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "onchange")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			// the pid file is kept out of the watch dir, so writing it
+			// doesn't trigger a rerun.
+			watchDir := filepath.Join(dir, "watch")
+			if err := os.Mkdir(watchDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			pidFile := filepath.Join(dir, "pid")
+
+			args := append([]string{"--probe=false", "--watch-dir", watchDir}, tt.flags...)
+			RootCmd.SetArgs(append(args, "--", "sh", "-c", fmt.Sprintf("echo $$ > %s; exec sleep 60", pidFile)))
+			done := make(chan error, 1)
+			go func() { done <- RootCmd.Execute() }()
+
+			var pid int
+			for i := 0; pid == 0 && i < 250; i++ {
+				time.Sleep(20 * time.Millisecond)
+				b, _ := ioutil.ReadFile(pidFile)
+				pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+			}
+			if pid == 0 {
+				t.Fatal("the command didn't start")
+			}
+			tt.cancel()
+
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("Run didn't return")
+			}
+			if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+				syscall.Kill(pid, syscall.SIGKILL)
+				t.Errorf("the command, pid %d, survived Run: %v", pid, err)
+			}
+		})
+	}
+}
+
+// TestWithUmask checks --umask reaches the command and leaves onchange's own
+// umask alone.
+func TestWithUmask(t *testing.T) {
+	own := syscall.Umask(022)
+	defer syscall.Umask(own)
+
+	tests := []struct {
+		umask int
+		want  string
+	}{
+		{-1, "0022"},
+		{0, "0000"},
+		{002, "0002"},
+		{0077, "0077"},
+	}
+	for _, tt := range tests {
+		r := &runner{umask: tt.umask}
+		out, err := r.withUmask(exec.Command("sh", "-c", "umask")).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(out)); got != tt.want {
+			t.Errorf("--umask %04o: command umask = %s, want %s", tt.umask, got, tt.want)
+		}
+		if mask := syscall.Umask(022); mask != 022 {
+			t.Errorf("--umask %04o: onchange umask = %04o, want 0022", tt.umask, mask)
+		}
+	}
+}
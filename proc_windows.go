@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// detach is a no-op; --adopt isn't supported on windows.
+func detach(c *exec.Cmd) {}
+
+// alive always reports false, so nothing is ever adopted.
+func alive(pid int) bool {
+	return false
+}
+
+func killSession(pid int) error {
+	return errors.New("not supported on windows")
+}
+
+func promoteSignal(pid int) error {
+	return errors.New("not supported on windows")
+}
+
+func reloadSignal(pid int) error {
+	return errors.New("not supported on windows")
+}
+
+// umaskArgs returns nil; windows has no umask.
+func umaskArgs(mask int) []string {
+	return nil
+}
+
+// dumpSignal is nil; windows has no SIGUSR2, so --dump-state does nothing.
+var dumpSignal os.Signal
+
+func runSignal(name string) (os.Signal, error) {
+	return nil, errors.New("--run-on-signal is not supported on windows")
+}
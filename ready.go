@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// readyAddr turns a --ready-port value, either a port or host:port, into an
+// address to dial.
+func readyAddr(s string) string {
+	if strings.Contains(s, ":") {
+		return s
+	}
+	return net.JoinHostPort("localhost", s)
+}
+
+// waitReady dials the ready port until it accepts a connection, logging when
+// the command is ready, or until readyTimeout passes or the command exits.
+// It runs in a goroutine of its own for each run.
+func (r *runner) waitReady(cur *run) {
+	start := time.Now()
+	deadline := time.After(r.readyTimeout)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-cur.exited:
+			return
+		case <-deadline:
+			cur.log().Warnf("%s not ready after %s", r.readyAddr, r.readyTimeout)
+			return
+		case <-tick.C:
+			c, err := net.DialTimeout("tcp", r.readyAddr, time.Second)
+			if err != nil {
+				continue
+			}
+			c.Close()
+			cur.log().Infof("ready on %s after %s", r.readyAddr, time.Since(start))
+			return
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// reload runs the reload command in place of restarting the main command,
+// when the main command is still running. It reports whether the reload went
+// through; if not, the caller restarts the command as usual. The reload
+// command blocks the loop until it exits, so it should be quick.
+func (r *runner) reload(cur *run) bool {
+	if r.reloadCmdStr == "" || cur.cmdStr != r.cmdStr || (r.cur == nil && r.adopted == 0) {
+		return false
+	}
+
+	// not newCmd: the reload's output stays out of the command log, which
+	// would otherwise be rotated for it.
+	args, err := splitCommand(r.reloadCmdStr, r.quoteStyle)
+	if err != nil {
+		cur.log().Warnf("can't reload, restarting instead: %s", err)
+		return false
+	}
+	cmd, err := r.transport.command(args)
+	if err != nil {
+		cur.log().Warnf("can't reload, restarting instead: %s", err)
+		return false
+	}
+	cmd = r.withUmask(cmd)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), "ONCHANGE_RUN_ID="+cur.id)
+	cmd.Env = append(cmd.Env, r.changedEnv(cur.changed)...)
+
+	cur.log().Infof("reloading: %s", r.reloadCmdStr)
+	start := time.Now()
+	err = cmd.Start()
+	if err == nil {
+		err = cmd.Wait()
+	}
+	if err != nil {
+		cur.log().Warnf("reload failed after %s, restarting instead: %s", time.Since(start), err)
+		return false
+	}
+	cur.log().Infof("reloaded in %s", time.Since(start))
+	return true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// transport turns a command's argv into the process that runs it, or
+// errEmptyCommand if argv is empty.
+type transport interface {
+	command(args []string) (*exec.Cmd, error)
+}
+
+// localTransport runs commands on this machine.
+type localTransport struct{}
+
+func (localTransport) command(args []string) (*exec.Cmd, error) {
+	if len(args) == 0 {
+		return nil, errEmptyCommand
+	}
+	return exec.Command(args[0], args[1:]...), nil
+}
+
+// sshTransport runs commands on another host over ssh. A tty is forced so that
+// killing ssh hangs up the remote command too, instead of leaving it running
+// on the far side; the tty also merges the remote stderr into stdout.
+//
+// The remote shell splits the command line again. With quote set, each
+// argument is quoted so it arrives intact; otherwise they're joined as is, so
+// pipes and the like still work with the default quote style.
+type sshTransport struct {
+	host  string
+	quote bool
+}
+
+func (t sshTransport) command(args []string) (*exec.Cmd, error) {
+	if len(args) == 0 {
+		return nil, errEmptyCommand
+	}
+	if t.quote {
+		quoted := make([]string, len(args))
+		for i, a := range args {
+			quoted[i] = shellQuote(a)
+		}
+		args = quoted
+	}
+	return exec.Command("ssh", "-tt", t.host, "--", strings.Join(args, " ")), nil
+}
+
+// sshExitConnFailed is the exit status ssh uses for its own errors, like
+// failing to connect, as opposed to the remote command failing.
+const sshExitConnFailed = 255
+
+// connFailed reports whether fin failed because ssh couldn't reach the remote
+// host.
+func (r *runner) connFailed(fin *run) bool {
+	if _, ok := r.transport.(sshTransport); !ok {
+		return false
+	}
+	e, ok := fin.err.(*exec.ExitError)
+	return ok && e.ExitCode() == sshExitConnFailed
+}
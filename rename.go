@@ -0,0 +1,76 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// renameWindow is how soon after a rename a create has to come to be taken
+// as its other half.
+const renameWindow = 100 * time.Millisecond
+
+// pair holds back a rename until the next event shows what it was. A rename
+// followed straight away by a create in the same directory is a file being
+// renamed, like a download or generator writing to a temp file and renaming
+// it into place; only the destination matters, so the rename is dropped, the
+// temp name forgotten, and the create goes through on its own. A rename
+// followed by anything else, including a create elsewhere or a late one, is
+// a file moved away, and is passed on as is. pair returns the events to
+// handle now.
+func (r *runner) pair(e fsnotify.Event) []fsnotify.Event {
+	if prev := r.renamed; prev != nil {
+		r.renamed = nil
+
+		if e.Op&fsnotify.Create == fsnotify.Create &&
+			filepath.Dir(e.Name) == filepath.Dir(prev.Name) &&
+			time.Since(r.renamedAt) <= renameWindow {
+			log.Debugf("%s was renamed to %s", prev.Name, e.Name)
+			r.forget(prev.Name)
+			return []fsnotify.Event{e}
+		}
+
+		if e.Op == fsnotify.Rename {
+			r.renamed, r.renamedAt = &e, time.Now()
+			return []fsnotify.Event{*prev}
+		}
+		return []fsnotify.Event{*prev, e}
+	}
+
+	if e.Op == fsnotify.Rename {
+		r.renamed, r.renamedAt = &e, time.Now()
+		return nil
+	}
+	return []fsnotify.Event{e}
+}
+
+// unpaired returns a held back rename that nothing followed, so it can be
+// handled as a file moved away.
+func (r *runner) unpaired() []fsnotify.Event {
+	if r.renamed == nil {
+		return nil
+	}
+	e := *r.renamed
+	r.renamed = nil
+	return []fsnotify.Event{e}
+}
+
+// forget drops p from the pending changes, once it turns out to have been a
+// temporary name.
+func (r *runner) forget(p string) {
+	p = filepath.Clean(p)
+	if r.files != nil {
+		delete(r.files, p)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.changed[:0]
+	for _, c := range r.changed {
+		if filepath.Clean(c) != p {
+			kept = append(kept, c)
+		}
+	}
+	r.changed = kept
+}
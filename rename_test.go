@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestPair(t *testing.T) {
+	rename := func(p string) fsnotify.Event { return fsnotify.Event{Name: p, Op: fsnotify.Rename} }
+	create := func(p string) fsnotify.Event { return fsnotify.Event{Name: p, Op: fsnotify.Create} }
+	write := func(p string) fsnotify.Event { return fsnotify.Event{Name: p, Op: fsnotify.Write} }
+
+	tests := []struct {
+		name     string
+		events   []fsnotify.Event
+		late     bool
+		want     []fsnotify.Event
+		unpaired []fsnotify.Event
+		changed  []string
+	}{
+		{
+			name:   "move in",
+			events: []fsnotify.Event{create("src/a.go")},
+			want:   []fsnotify.Event{create("src/a.go")},
+		},
+		{
+			name:     "move out",
+			events:   []fsnotify.Event{rename("src/a.go")},
+			unpaired: []fsnotify.Event{rename("src/a.go")},
+		},
+		{
+			name:    "rename within",
+			events:  []fsnotify.Event{rename("src/.a.go.tmp"), create("src/a.go")},
+			want:    []fsnotify.Event{create("src/a.go")},
+			changed: []string{"src/b.go"},
+		},
+		{
+			name:    "create in another dir",
+			events:  []fsnotify.Event{rename("src/.a.go.tmp"), create("lib/b.go")},
+			want:    []fsnotify.Event{rename("src/.a.go.tmp"), create("lib/b.go")},
+			changed: []string{"src/.a.go.tmp", "src/b.go"},
+		},
+		{
+			name:    "late create",
+			events:  []fsnotify.Event{rename("src/.a.go.tmp"), create("src/a.go")},
+			late:    true,
+			want:    []fsnotify.Event{rename("src/.a.go.tmp"), create("src/a.go")},
+			changed: []string{"src/.a.go.tmp", "src/b.go"},
+		},
+		{
+			name:     "rename then rename",
+			events:   []fsnotify.Event{rename("src/a.go"), rename("src/b.go")},
+			want:     []fsnotify.Event{rename("src/a.go")},
+			unpaired: []fsnotify.Event{rename("src/b.go")},
+		},
+		{
+			name:   "rename then write",
+			events: []fsnotify.Event{rename("src/a.go"), write("src/b.go")},
+			want:   []fsnotify.Event{rename("src/a.go"), write("src/b.go")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &runner{mu: &sync.Mutex{}, changed: []string{"src/.a.go.tmp", "src/b.go"}}
+			var got []fsnotify.Event
+			for _, e := range tt.events {
+				if tt.late && r.renamed != nil {
+					r.renamedAt = r.renamedAt.Add(-2 * renameWindow)
+				}
+				got = append(got, r.pair(e)...)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pair = %v, want %v", got, tt.want)
+			}
+			if u := r.unpaired(); !reflect.DeepEqual(u, tt.unpaired) {
+				t.Errorf("unpaired = %v, want %v", u, tt.unpaired)
+			}
+			if tt.changed != nil && !reflect.DeepEqual(r.changed, tt.changed) {
+				t.Errorf("changed = %q, want %q", r.changed, tt.changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import "fmt"
+
+// names for --restart-strategy.
+const (
+	restartKill   = "kill"
+	restartReload = "reload"
+	restartSignal = "signal"
+	restartQueue  = "queue"
+)
+
+// restartStrategy is what happens to the command that's running when a new
+// run is due.
+type restartStrategy interface {
+	// hold reports whether a due run has to wait, its changes left pending
+	// until the next tick.
+	hold(r *runner) bool
+
+	// restart makes cur the current run, or hands it to the running command
+	// some other way.
+	restart(r *runner, cur *run) error
+}
+
+// newRestartStrategy returns the strategy for name. An empty name is the
+// default: reload with --reload-command, kill otherwise.
+func (r *runner) newRestartStrategy(name string) (restartStrategy, error) {
+	if name == "" {
+		name = restartKill
+		if r.reloadCmdStr != "" {
+			name = restartReload
+		}
+	}
+	switch name {
+	case restartKill:
+		return killRestart{}, nil
+	case restartReload:
+		if r.reloadCmdStr == "" {
+			return nil, fmt.Errorf("restart strategy %s needs --reload-command", name)
+		}
+		return reloadRestart{}, nil
+	case restartSignal:
+		return signalRestart{}, nil
+	case restartQueue:
+		return queueRestart{}, nil
+	}
+	return nil, fmt.Errorf("unknown restart strategy: %s", name)
+}
+
+// killRestart kills the running command and starts a new one, or promotes
+// the --warm-command spare in its place.
+type killRestart struct{}
+
+func (killRestart) hold(r *runner) bool {
+	return false
+}
+
+func (killRestart) restart(r *runner, cur *run) error {
+	if ok, err := r.promote(cur); err != nil || ok {
+		return err
+	}
+	cur.log().Infof("running command: %s", cur.cmdStr)
+	r.cancelRetry()
+	if err := r.stop(); err != nil {
+		return err
+	}
+	return r.start(cur)
+}
+
+// reloadRestart runs --reload-command while the command is running, and
+// kills and restarts it if that fails or nothing's running.
+type reloadRestart struct{ killRestart }
+
+func (s reloadRestart) restart(r *runner, cur *run) error {
+	if r.reload(cur) {
+		return nil
+	}
+	return s.killRestart.restart(r, cur)
+}
+
+// signalRestart sends the running command SIGHUP to reload itself, and
+// kills and restarts it if that fails or nothing's running.
+type signalRestart struct{ killRestart }
+
+func (s signalRestart) restart(r *runner, cur *run) error {
+	pid := r.adopted
+	if r.cur != nil {
+		pid = r.cur.cmd.Process.Pid
+	}
+	if pid == 0 || cur.cmdStr != r.cmdStr {
+		return s.killRestart.restart(r, cur)
+	}
+	if err := reloadSignal(pid); err != nil {
+		cur.log().Warnf("can't signal the command, restarting instead: %s", err)
+		return s.killRestart.restart(r, cur)
+	}
+	cur.log().Info("sent the command SIGHUP to reload")
+	return nil
+}
+
+// queueRestart never kills the command: a due run waits for the running one
+// to exit, then starts.
+type queueRestart struct{ killRestart }
+
+func (queueRestart) hold(r *runner) bool {
+	return r.cur != nil
+}
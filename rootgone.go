@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// rootGone checks whether e means a watch dir itself was removed or moved
+// away. Unless waitForRoot is set, that ends the session with exitRootRemoved;
+// otherwise the dir is forgotten until it reappears. A remove event for a dir
+// that's still there, like one deleted and recreated in between, doesn't
+// count.
+func (r *runner) rootGone(e fsnotify.Event) error {
+	if e.Op&(fsnotify.Remove|fsnotify.Rename) == 0 {
+		return nil
+	}
+
+	dir := filepath.Clean(e.Name)
+	if !r.isRoot(dir) || r.missing[dir] {
+		return nil
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	// targets come and go; the next refresh sorts out ones that are gone.
+	if !r.waitForRoot && r.targetsCmdStr == "" {
+		r.stop()
+		return &exitError{code: exitRootRemoved, reason: fmt.Sprintf("watch dir %s was removed", dir)}
+	}
+
+	log.Warnf("watch dir %s was removed, waiting for it to come back", dir)
+	prefix := dir + string(filepath.Separator)
+	for p := range r.watched {
+		if filepath.Clean(p) == dir || strings.HasPrefix(p, prefix) {
+			delete(r.watched, p)
+		}
+	}
+	r.missing[dir] = true
+	return nil
+}
+
+// restoreRoots watches any missing watch dirs that have come back.
+func (r *runner) restoreRoots(w *fsnotify.Watcher) error {
+	for dir := range r.missing {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		delete(r.missing, dir)
+		log.Infof("watch dir %s is back", dir)
+		if r.polled(dir) {
+			continue
+		}
+		if err := r.addTree(w, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
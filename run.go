@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// run is a single invocation of a command.
+type run struct {
+	// id identifies the run in logs and to the command, as ONCHANGE_RUN_ID.
+	// Retries keep the id of the run they retry.
+	id string
+
+	cmdStr string
+	cmd    *exec.Cmd
+
+	// pipe are the --pipe-to stages after cmd, and pipeFiles the ends of the
+	// pipes between them, closed once they've started. failed is the stage
+	// whose error is err.
+	pipe      []*exec.Cmd
+	pipeFiles []*os.File
+	failed    *exec.Cmd
+
+	// changed are the paths whose changes triggered the run, each once, oldest first.
+	changed []string
+
+	// op is what happened to the last of them, like write or remove.
+	op string
+
+	// files are the changed paths passed to the command as arguments, with
+	// --append-files, and more are those left for the chunks after this one.
+	// listed is set once they've been worked out.
+	files  []string
+	more   []string
+	listed bool
+
+	// pendingSince is when the first of the changes came in; zero for the run
+	// at startup.
+	pendingSince time.Time
+
+	// latency is how long the command took to start after pendingSince.
+	latency time.Duration
+
+	// stdin is the changed file fed to the command, with stdinFromChanged.
+	stdin *os.File
+
+	// attempt counts from 1, going up each time a failed run is retried.
+	attempt int
+
+	// timer fires when the attempt has been going for longer than retryTimeout.
+	timer *time.Timer
+
+	// startedAt is when the command started.
+	startedAt time.Time
+
+	// deadline is when timer fires, moved back by control lines.
+	deadline time.Time
+
+	// timedOut is set when the attempt was killed for running too long.
+	timedOut bool
+
+	// done is set once the command prints the --done-marker.
+	done bool
+
+	// warm marks a spare started with --warm-command, waiting to be promoted;
+	// ready is set once it says it's ready.
+	warm  bool
+	ready bool
+
+	// exited is closed once the command has exited.
+	exited chan struct{}
+
+	// tmpDir is scratch space for this run only, passed as ONCHANGE_TMPDIR.
+	tmpDir string
+
+	// err is what cmd.Wait returned.
+	err error
+
+	// summary collects the output lines --summary-regex matches.
+	summary *summary
+}
+
+// newRunID returns a short random id for a run.
+func newRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// log returns a logger that tags lines with the run's id.
+func (fin *run) log() *logrus.Entry {
+	return log.WithField("run", fin.id)
+}
+
+// cleanup removes the run's temp dir and closes its stdin.
+func (fin *run) cleanup() {
+	if fin.stdin != nil {
+		fin.stdin.Close()
+		fin.stdin = nil
+	}
+
+	if fin.tmpDir == "" {
+		return
+	}
+	if err := os.RemoveAll(fin.tmpDir); err != nil {
+		fin.log().Warnf("can't remove %s: %s", fin.tmpDir, err)
+	}
+}
+
+// start launches cur as the current run. The caller fills in its id, command,
+// changed paths and attempt.
+func (r *runner) start(cur *run) error {
+	cmd, err := r.runCmd(cur)
+	if err != nil {
+		return &CommandStartError{Command: cur.cmdStr, Err: err}
+	}
+	cur.cmd = cmd
+
+	cur.tmpDir, err = ioutil.TempDir("", "onchange-run")
+	if err != nil {
+		return &CommandStartError{Command: cur.cmdStr, Err: err}
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "ONCHANGE_TMPDIR="+cur.tmpDir, "ONCHANGE_RUN_ID="+cur.id)
+	cmd.Env = append(cmd.Env, r.changedEnv(cur.changed)...)
+	if cur.op != "" {
+		cmd.Env = append(cmd.Env, "ONCHANGE_OP="+cur.op)
+	}
+	if len(cur.changed) > 0 {
+		p := cur.changed[len(cur.changed)-1]
+		if pkg := goPackage(p); pkg != "" {
+			cmd.Env = append(cmd.Env, "ONCHANGE_PACKAGE="+pkg)
+		}
+	}
+	if r.packageOrder && len(cur.changed) > 0 {
+		if pkgs := packageOrder(cur.changed); len(pkgs) > 0 {
+			cmd.Env = append(cmd.Env, "ONCHANGE_PACKAGES="+strings.Join(pkgs, " "))
+		}
+	}
+
+	if r.stdinFromChanged && len(cur.changed) > 0 {
+		// with several changed files, the most recent one wins.
+		p := cur.changed[len(cur.changed)-1]
+		f, err := os.Open(p)
+		if err != nil {
+			cur.log().Warnf("can't feed %s to the command: %s", p, err)
+		} else {
+			cur.stdin = f
+			cmd.Stdin = f
+		}
+	}
+
+	if r.stdinTerminal {
+		// every run gets the same fd, so a restarted command picks up
+		// reading where the last one left off.
+		cmd.Stdin = os.Stdin
+	}
+
+	if cur.warm {
+		cmd.Env = append(cmd.Env, "ONCHANGE_WARM=1")
+	}
+	if r.doneMarker != "" {
+		cmd.Stdout = &doneWriter{w: cmd.Stdout, cur: cur, marker: r.doneMarker, out: r.control}
+		if r.mergeOutput {
+			// the marker can come on stderr too, and both streams keep
+			// sharing one writer.
+			cmd.Stderr = cmd.Stdout
+		}
+	}
+	if r.parseControl || cur.warm {
+		cmd.Stdout = &controlWriter{w: cmd.Stdout, cur: cur, out: r.control}
+		if r.mergeOutput {
+			cmd.Stderr = cmd.Stdout
+		}
+	}
+	r.summarize(cur)
+
+	if cur.cmdStr == r.cmdStr && !cur.warm {
+		if err := r.pipeTo(cur); err != nil {
+			cur.closePipes()
+			cur.cleanup()
+			return &CommandStartError{Command: cur.cmdStr, Err: err}
+		}
+	}
+
+	r.beginGroup(cur)
+	if err := r.startStages(cur); err != nil {
+		r.endGroup()
+		cur.cleanup()
+		return &CommandStartError{Command: cur.cmdStr, Err: err}
+	}
+	cur.startedAt = time.Now()
+
+	if cur.attempt == 1 && !cur.pendingSince.IsZero() {
+		cur.latency = time.Since(cur.pendingSince)
+		runLatency.observe(cur.latency)
+		cur.log().WithField("latency", cur.latency).Debug("command started")
+	}
+
+	cur.exited = make(chan struct{})
+	go func() {
+		cur.err = cur.wait()
+		close(cur.exited)
+		r.done <- cur
+	}()
+
+	if cur.warm {
+		r.spare = cur
+		return nil
+	}
+
+	if r.adopt {
+		r.writePid(cmd.Process.Pid)
+	}
+	r.arm(cur)
+	r.cur = cur
+
+	if r.readyAddr != "" {
+		go r.waitReady(cur)
+	}
+	return nil
+}
+
+// arm starts cur's --retry-timeout clock.
+func (r *runner) arm(cur *run) {
+	if r.retryTimeout > 0 {
+		cur.timer = time.NewTimer(r.retryTimeout)
+		cur.deadline = time.Now().Add(r.retryTimeout)
+	}
+}
+
+// stop kills the current run, if there is one, or the adopted command, along
+// with any daemon the command left behind.
+func (r *runner) stop() error {
+	if err := r.stopDaemon(); err != nil {
+		return err
+	}
+
+	if r.adopted != 0 {
+		log.Debugf("killing adopted process %d", r.adopted)
+		if err := killSession(r.adopted); err != nil && alive(r.adopted) {
+			return &CommandStopError{Command: r.cmdStr, Err: err}
+		}
+		r.adopted = 0
+	}
+
+	if r.cur == nil {
+		return nil
+	}
+
+	cur := r.cur
+	r.cur = nil
+	r.endGroup()
+	if cur.timer != nil {
+		cur.timer.Stop()
+	}
+
+	cur.log().Debug("killing current process")
+	if err := cur.kill(); err != nil {
+		return &CommandStopError{Command: cur.cmdStr, Err: err}
+	}
+	cur.cleanup()
+
+	// wait for it to be gone, so the next run doesn't race it for ports and
+	// files, and onchange doesn't exit with it still around.
+	if cur.exited != nil {
+		select {
+		case <-cur.exited:
+		case <-time.After(stopWait):
+			cur.log().Warnf("command still hasn't exited %s after being killed", stopWait)
+		}
+	}
+	return nil
+}
+
+// stopWait is how long stop waits for a killed command to exit.
+const stopWait = 2 * time.Second
+
+// timeout returns a channel that fires when the current attempt runs out of
+// time, or nil if there's nothing to time out.
+func (r *runner) timeout() <-chan time.Time {
+	if r.cur == nil || r.cur.timer == nil || r.cur.done {
+		return nil
+	}
+	return r.cur.timer.C
+}
+
+// finished handles a run exiting. Runs that were killed to make way for a new
+// one are done with; a failed current run is retried while it has attempts
+// left.
+func (r *runner) finished(fin *run) error {
+	fin.cleanup()
+	if fin == r.spare {
+		fin.log().Warnf("warm spare exited: %v", fin.err)
+		r.spare = nil
+		return nil
+	}
+	if fin != r.cur {
+		return nil
+	}
+	r.cur = nil
+	r.endGroup()
+	r.last = fin
+	r.lastRunEnd = time.Now()
+	if fin.done && fin.err != nil {
+		// it already said it was done; how it goes after that doesn't count.
+		fin.log().Debugf("command exited after its done marker: %v", fin.err)
+		fin.err = nil
+	}
+	if r.doneMarker != "" && !fin.done && fin.err == nil {
+		fin.log().Warn("command exited without printing its done marker")
+	}
+	r.countRun(fin)
+	// saved once the failure streaks below are updated.
+	defer r.saveState()
+	if r.statusFile != "" {
+		r.writeStatus(fin)
+	}
+	r.logSummary(fin)
+	if r.notify {
+		r.oscNotify(fin)
+	}
+	if fin.timer != nil {
+		fin.timer.Stop()
+	}
+	if r.adopt {
+		os.Remove(r.pidFile())
+	}
+
+	if r.connFailed(fin) {
+		r.connFailures++
+		if r.connFailures > r.remoteRetries {
+			fin.log().Errorf("giving up after %d failed connections", r.connFailures)
+			r.connFailures = 0
+			return nil
+		}
+		fin.log().Warnf("can't connect to the remote host, retrying on the next tick (%d of %d)", r.connFailures, r.remoteRetries)
+		r.mu.Lock()
+		r.queue(fin.cmdStr)
+		later := r.changed
+		r.changed = nil
+		for _, p := range append(fin.changed, later...) {
+			r.noteChanged(p)
+		}
+		r.mu.Unlock()
+		return nil
+	}
+	r.connFailures = 0
+
+	if fin.err == nil {
+		r.quickCrashes = 0
+		r.succeededRun(fin)
+		if len(fin.more) > 0 {
+			return r.nextChunk(fin)
+		}
+		r.startupOver()
+		return nil
+	}
+	if !fin.timedOut {
+		fin.log().Error(fin.err)
+	}
+	r.failedRun(fin)
+
+	if fin.attempt > r.retries {
+		r.startupOver()
+		return nil
+	}
+	next := &run{id: fin.id, cmdStr: fin.cmdStr, changed: fin.changed, op: fin.op, attempt: fin.attempt + 1, files: fin.files, more: fin.more, listed: fin.listed}
+	d := r.retryDelay(fin)
+	if d <= 0 {
+		fin.log().Infof("retrying command: %s (attempt %d of %d)", fin.cmdStr, next.attempt, r.retries+1)
+		return r.start(next)
+	}
+	fin.log().Infof("retrying command in %s: %s (attempt %d of %d)", d, fin.cmdStr, next.attempt, r.retries+1)
+	r.retry = next
+	r.retryTimer = time.NewTimer(d)
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// selfWritten reports whether p is under one of the --output-dir dirs while
+// the command is running, or within a tick of it finishing. Those changes are
+// the build writing its own output, like a compiler cache in the tree, and
+// would otherwise trigger the next build.
+func (r *runner) selfWritten(p string) bool {
+	if len(r.outputDirs) < 1 {
+		return false
+	}
+	if r.cur == nil && r.adopted == 0 && time.Since(r.lastRunEnd) > r.outputGrace {
+		return false
+	}
+
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return false
+	}
+	for _, dir := range r.outputDirs {
+		if abs == dir || strings.HasPrefix(abs, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// flush runs the pending command, if a change is waiting for the next tick,
+// and waits for it to finish so onchange can exit on sig. The command that
+// was already running is stopped first; it got the same ctrl-c anyway.
+// Retries without --retry-delay run as part of the wait; one that's delayed
+// is dropped, with a warning, rather than holding up the exit.
+func (r *runner) flush(sig os.Signal) error {
+	r.mu.Lock()
+	next := ""
+	var changed []string
+	var op string
+	if r.resetNext {
+		next, changed, op = r.nextCmdStr, r.collapse(r.changed), r.lastOp
+		r.resetNext = false
+		r.changed = nil
+		r.lastOp = ""
+	}
+	r.mu.Unlock()
+
+	if err := r.stop(); err != nil {
+		return err
+	}
+
+	if next != "" && !r.skipRun(&run{cmdStr: next, changed: changed}) {
+		cur := &run{id: newRunID(), cmdStr: next, changed: changed, op: op, attempt: 1}
+		cur.log().Infof("running pending command before exiting: %s", next)
+		if err := r.start(cur); err != nil {
+			return err
+		}
+		for r.cur != nil {
+			if err := r.finished(<-r.done); err != nil {
+				return err
+			}
+		}
+	}
+	if r.retry != nil {
+		r.retry.log().Warnf("exiting without retrying command: %s (attempt %d of %d)", r.retry.cmdStr, r.retry.attempt, r.retries+1)
+		r.cancelRetry()
+	}
+
+	return &exitError{code: exitInterrupted, reason: fmt.Sprintf("got %s", sig)}
+}
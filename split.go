@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Quote styles for --quote-style, deciding how a command string is split
+// into arguments.
+const (
+	// quoteNone splits on every single space, with no quoting at all.
+	quoteNone = "none"
+
+	// quoteShell splits like a posix shell: runs of whitespace separate
+	// arguments, 'single quotes' are literal, "double quotes" allow \" and
+	// \\, and a backslash outside quotes escapes the next character.
+	quoteShell = "shell"
+
+	// quoteShellEscaped splits on runs of whitespace, where only a backslash
+	// escapes the next character; quotes are ordinary characters.
+	quoteShellEscaped = "shell-escaped"
+)
+
+// errEmptyCommand is returned for a command with nothing to run.
+var errEmptyCommand = errors.New("empty command")
+
+// splitCommand splits s into arguments according to style. A command that's
+// empty or all whitespace is an error, whatever the style.
+func splitCommand(s, style string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, errEmptyCommand
+	}
+	switch style {
+	case quoteNone, "":
+		return strings.Split(s, " "), nil
+	case quoteShell:
+		return splitWords(s, true)
+	case quoteShellEscaped:
+		return splitWords(s, false)
+	}
+	return nil, fmt.Errorf("unknown quote style: %s", style)
+}
+
+// splitWords splits s on unescaped whitespace, honoring backslash escapes
+// and, when quotes is set, single and double quotes.
+func splitWords(s string, quotes bool) ([]string, error) {
+	var args []string
+	var cur []rune
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			if quote == '"' && c != '"' && c != '\\' {
+				cur = append(cur, '\\')
+			}
+			cur = append(cur, c)
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				cur = append(cur, c)
+			}
+		case c == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				cur = append(cur, c)
+			}
+		case quotes && (c == '\'' || c == '"'):
+			quote, inWord = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				args = append(args, string(cur))
+				cur, inWord = nil, false
+			}
+		default:
+			cur, inWord = append(cur, c), true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if inWord {
+		args = append(args, string(cur))
+	}
+	return args, nil
+}
+
+// shellQuote quotes s for a posix shell, leaving it alone if it's safe.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,+@%") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		s, style string
+		want     []string
+		err      bool
+	}{
+		{"go test ./...", quoteNone, []string{"go", "test", "./..."}, false},
+		{"a  b", quoteNone, []string{"a", "", "b"}, false},
+		{`sh -c 'go test'`, quoteShell, []string{"sh", "-c", "go test"}, false},
+		{`echo a\ b "c"`, quoteShellEscaped, []string{"echo", "a b", `"c"`}, false},
+		{"''", quoteShell, []string{""}, false},
+		{"'a", quoteShell, nil, true},
+		{`a\`, quoteShellEscaped, nil, true},
+		{"a", "bogus", nil, true},
+
+		// nothing to run, in any style.
+		{"", quoteNone, nil, true},
+		{"   ", quoteNone, nil, true},
+		{"   ", quoteShell, nil, true},
+		{" \t\n", quoteShellEscaped, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.s, tt.style)
+		if (err != nil) != tt.err {
+			t.Errorf("splitCommand(%q, %q) error = %v, want error %v", tt.s, tt.style, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommand(%q, %q) = %q, want %q", tt.s, tt.style, got, tt.want)
+		}
+	}
+}
+
+func TestTransportEmptyCommand(t *testing.T) {
+	for _, tr := range []transport{localTransport{}, sshTransport{host: "h"}, sshTransport{host: "h", quote: true}} {
+		if _, err := tr.command(nil); err != errEmptyCommand {
+			t.Errorf("%T.command(nil) error = %v, want %v", tr, err, errEmptyCommand)
+		}
+	}
+}
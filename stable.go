@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+)
+
+// track records the size of a changed file so it can be checked for
+// stability before the next run. Callers must hold r.mu.
+func (r *runner) track(p string) {
+	if !r.waitStable {
+		return
+	}
+
+	i, err := os.Stat(p)
+	if err != nil || i.IsDir() {
+		return
+	}
+	r.sizes[p] = i.Size()
+}
+
+// settled reports whether every tracked file has kept the same size since it
+// was last seen, meaning whoever was writing it is done. Files that are still
+// growing get their new size recorded, so the next tick checks again. Callers
+// must hold r.mu.
+func (r *runner) settled() bool {
+	ok := true
+	for p, size := range r.sizes {
+		i, err := os.Stat(p)
+		if err != nil {
+			delete(r.sizes, p)
+			continue
+		}
+		if i.Size() != size {
+			log.Debugf("%s is still being written", p)
+			r.sizes[p] = i.Size()
+			ok = false
+		}
+	}
+
+	if ok {
+		r.sizes = map[string]int64{}
+	}
+	return ok
+}
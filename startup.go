@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// startupCheck returns an exitError if --startup-timeout has passed while
+// onchange is still starting up, doing what, and nil otherwise.
+func (r *runner) startupCheck(doing string) error {
+	if r.startupDeadline.IsZero() || r.startupDone || time.Now().Before(r.startupDeadline) {
+		return nil
+	}
+	return &exitError{code: exitStartupTimeout, reason: fmt.Sprintf("startup took longer than %s: still %s", r.startupTimeout, doing)}
+}
+
+// startupOver marks startup as done, once the watches are set up and the
+// first run has finished, if there is one.
+func (r *runner) startupOver() {
+	if r.startupDone {
+		return
+	}
+	r.startupDone = true
+	if !r.startupDeadline.IsZero() {
+		log.Debugf("startup took %s", r.startupTimeout-time.Until(r.startupDeadline))
+	}
+}
+
+// startupExpired returns a channel that fires when --startup-timeout runs
+// out, or nil if there's none or startup is over.
+func (r *runner) startupExpired() <-chan time.Time {
+	if r.startupDeadline.IsZero() || r.startupDone {
+		return nil
+	}
+	return time.After(time.Until(r.startupDeadline))
+}
+
+// osExit is os.Exit, swapped out in tests.
+var osExit = os.Exit
+
+// exit ends onchange with err's exit code if it's an exitError; anything
+// else goes back to cobra, masked, to be printed.
+func (r *runner) exit(err error) error {
+	if e, ok := err.(*exitError); ok {
+		log.Info(e)
+		osExit(e.code)
+	}
+	return r.masks.maskError(err)
+}
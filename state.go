@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// statePrefix starts the name of the temp file a new state file is written
+// to before it's renamed into place.
+const statePrefix = ".onchange-state"
+
+// sessionState is what --state-file keeps across restarts of onchange: run
+// counts, and the failure streaks --verbose-after and crash loop backoff
+// go by.
+type sessionState struct {
+	// Since is when the first session using the file started.
+	Since time.Time `json:"since"`
+
+	Runs           int `json:"runs"`
+	Failed         int `json:"failed"`
+	FailuresInARow int `json:"failures_in_a_row"`
+	QuickCrashes   int `json:"quick_crashes"`
+
+	Last *lastRun `json:"last_run,omitempty"`
+}
+
+// lastRun is how the last run ended, as kept in the state file.
+type lastRun struct {
+	Command  string    `json:"command"`
+	ID       string    `json:"id"`
+	ExitCode int       `json:"exit_code"`
+	Time     time.Time `json:"time"`
+}
+
+// loadState picks up where the last session left off. A missing state file
+// starts a fresh one; so does one that can't be read, with a warning.
+func (r *runner) loadState() {
+	r.session = sessionState{Since: time.Now().UTC()}
+
+	b, err := ioutil.ReadFile(r.stateFile)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Warnf("reading state file, starting afresh: %s", err)
+		return
+	}
+	var s sessionState
+	if err := json.Unmarshal(b, &s); err != nil || s.Since.IsZero() || s.Runs < 0 || s.Failed < 0 || s.FailuresInARow < 0 || s.QuickCrashes < 0 {
+		log.Warnf("state file %s is corrupt, starting afresh", r.stateFile)
+		return
+	}
+
+	r.session = s
+	r.failures = s.FailuresInARow
+	r.quickCrashes = s.QuickCrashes
+	log.Infof("restored state from %s: %d runs, %d failed, since %s", r.stateFile, s.Runs, s.Failed, s.Since.Format(time.RFC3339))
+}
+
+// countRun adds fin to the session's counts. The command is masked, since
+// the state file is as public as the log.
+func (r *runner) countRun(fin *run) {
+	r.session.Runs++
+	if fin.err != nil {
+		r.session.Failed++
+	}
+	r.session.Last = &lastRun{
+		Command:  r.masks.mask(fin.cmdStr),
+		ID:       fin.id,
+		ExitCode: fin.exitCode(),
+		Time:     time.Now().UTC(),
+	}
+}
+
+// saveState writes the state file, replacing it atomically so a crash
+// mid-write leaves the previous one.
+func (r *runner) saveState() {
+	if r.stateFile == "" {
+		return
+	}
+	r.session.FailuresInARow = r.failures
+	r.session.QuickCrashes = r.quickCrashes
+
+	b, err := json.MarshalIndent(r.session, "", "  ")
+	if err != nil {
+		log.Warnf("writing state file: %s", err)
+		return
+	}
+	f, err := ioutil.TempFile(filepath.Dir(r.stateFile), statePrefix)
+	if err != nil {
+		log.Warnf("writing state file: %s", err)
+		return
+	}
+	f.Write(append(b, '\n'))
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		log.Warnf("writing state file: %s", err)
+		return
+	}
+	if err := os.Rename(f.Name(), r.stateFile); err != nil {
+		os.Remove(f.Name())
+		log.Warnf("writing state file: %s", err)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestCountRunMasks(t *testing.T) {
+	masks, err := parseMasks([]string{"token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		masks  masker
+		cmdStr string
+		want   string
+	}{
+		{nil, "deploy --token s3cret", "deploy --token s3cret"},
+		{masks, "deploy --token s3cret", "deploy --token " + masked},
+		{masks, "make", "make"},
+	}
+	for _, tt := range tests {
+		r := &runner{masks: tt.masks}
+		r.countRun(&run{id: "1", cmdStr: tt.cmdStr})
+		if got := r.session.Last.Command; got != tt.want {
+			t.Errorf("countRun(%q): saved command = %q, want %q", tt.cmdStr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// statusPrefix starts the name of the temp file a new status file is written
+// to before it's renamed into place.
+const statusPrefix = ".onchange-status"
+
+// exitCode returns fin's exit code, or -1 if it was killed by a signal. For
+// a pipeline, it's that of the stage that failed.
+func (fin *run) exitCode() int {
+	if fin.err == nil {
+		return 0
+	}
+	c := fin.cmd
+	if fin.failed != nil {
+		c = fin.failed
+	}
+	if c.ProcessState == nil {
+		return -1
+	}
+	return c.ProcessState.ExitCode()
+}
+
+// writeStatus records how fin ended in the status file, replacing it
+// atomically so readers never see half a file. The format is key=value lines:
+//
+//	exit_code=0
+//	time=2018-01-02T15:04:05Z
+//	run=1a2b3c4d
+//
+// exit_code is -1 when the command was killed by a signal.
+func (r *runner) writeStatus(fin *run) {
+	code := fin.exitCode()
+
+	f, err := ioutil.TempFile(filepath.Dir(r.statusFile), statusPrefix)
+	if err != nil {
+		fin.log().Warnf("writing status file: %s", err)
+		return
+	}
+	fmt.Fprintf(f, "exit_code=%d\ntime=%s\nrun=%s\n", code, time.Now().UTC().Format(time.RFC3339), fin.id)
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		fin.log().Warnf("writing status file: %s", err)
+		return
+	}
+	if err := os.Rename(f.Name(), r.statusFile); err != nil {
+		os.Remove(f.Name())
+		fin.log().Warnf("writing status file: %s", err)
+	}
+}
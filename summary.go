@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// summary collects the lines of a run's output that match --summary-regex,
+// to be logged as one line when the run finishes.
+type summary struct {
+	re *regexp.Regexp
+
+	mu      sync.Mutex
+	matches int
+	// last is the latest match and its groups, as FindStringSubmatch has them.
+	last []string
+
+	writers []*summaryWriter
+}
+
+// summaryWriter passes a command's output through, feeding each complete
+// line to a summary. stdout and stderr each get their own, unless they're
+// merged.
+type summaryWriter struct {
+	w    io.Writer
+	s    *summary
+	line []byte
+}
+
+func (sw *summaryWriter) Write(p []byte) (int, error) {
+	if _, err := sw.w.Write(p); err != nil {
+		return 0, err
+	}
+	for rest := p; len(rest) > 0; {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			sw.line = append(sw.line, rest...)
+			break
+		}
+		sw.line = append(sw.line, rest[:i]...)
+		rest = rest[i+1:]
+		sw.s.add(strings.TrimRight(string(sw.line), "\r"))
+		sw.line = sw.line[:0]
+	}
+	return len(p), nil
+}
+
+// flush matches any last line the command printed without a newline. The
+// command has to have exited.
+func (s *summary) flush() {
+	for _, sw := range s.writers {
+		if len(sw.line) > 0 {
+			s.add(strings.TrimRight(string(sw.line), "\r"))
+			sw.line = nil
+		}
+	}
+}
+
+func (s *summary) add(line string) {
+	m := s.re.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	s.mu.Lock()
+	s.matches++
+	s.last = m
+	s.mu.Unlock()
+}
+
+// format fills in tmpl from the matches so far: {name} is the named group
+// from the latest matching line, {0} the whole match, and {matches} how many
+// lines matched. With no template, it's the groups as name=value, or the
+// match itself if there are none. It returns "" if nothing matched.
+func (s *summary) format(tmpl string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.matches == 0 {
+		return ""
+	}
+
+	names := s.re.SubexpNames()
+	if tmpl == "" {
+		var parts []string
+		for i, name := range names {
+			if name != "" {
+				parts = append(parts, name+"="+s.last[i])
+			}
+		}
+		if len(parts) == 0 {
+			return s.last[0]
+		}
+		return strings.Join(parts, " ")
+	}
+
+	pairs := []string{"{matches}", strconv.Itoa(s.matches), "{0}", s.last[0]}
+	for i, name := range names {
+		if name != "" {
+			pairs = append(pairs, "{"+name+"}", s.last[i])
+		}
+	}
+	return strings.NewReplacer(pairs...).Replace(tmpl)
+}
+
+// summarize wraps cur's output to collect its summary, with --summary-regex.
+// Merged output shares one writer, and keeps sharing it, so exec still
+// copies both streams with a single goroutine.
+func (r *runner) summarize(cur *run) {
+	if r.summaryRe == nil || cur.warm {
+		return
+	}
+	s := &summary{re: r.summaryRe}
+	stdout := &summaryWriter{w: cur.cmd.Stdout, s: s}
+	s.writers = []*summaryWriter{stdout}
+	if cur.cmd.Stdout == cur.cmd.Stderr {
+		cur.cmd.Stdout, cur.cmd.Stderr = stdout, stdout
+	} else {
+		stderr := &summaryWriter{w: cur.cmd.Stderr, s: s}
+		s.writers = append(s.writers, stderr)
+		cur.cmd.Stdout, cur.cmd.Stderr = stdout, stderr
+	}
+	cur.summary = s
+}
+
+// logSummary logs fin's summary, if its output matched.
+func (r *runner) logSummary(fin *run) {
+	if fin.summary == nil {
+		return
+	}
+	fin.summary.flush()
+	if msg := fin.summary.format(r.summaryTmpl); msg != "" {
+		fin.log().Infof("summary: %s", msg)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"regexp"
+	"testing"
+)
+
+func TestSummarizeStreams(t *testing.T) {
+	tests := []struct {
+		name    string
+		merged  bool
+		writers int
+	}{
+		{"separate", false, 2},
+		{"merged", true, 1},
+	}
+	for _, tt := range tests {
+		r := &runner{summaryRe: regexp.MustCompile(`ok`)}
+		cur := &run{cmd: exec.Command("true")}
+		var out, errOut bytes.Buffer
+		cur.cmd.Stdout, cur.cmd.Stderr = &out, &errOut
+		if tt.merged {
+			cur.cmd.Stderr = &out
+		}
+
+		r.summarize(cur)
+		if same := cur.cmd.Stdout == cur.cmd.Stderr; same != tt.merged {
+			t.Errorf("%s: one writer for both streams = %v, want %v", tt.name, same, tt.merged)
+		}
+		if n := len(cur.summary.writers); n != tt.writers {
+			t.Errorf("%s: %d summary writers, want %d", tt.name, n, tt.writers)
+		}
+
+		cur.cmd.Stdout.Write([]byte("ok 1\n"))
+		cur.cmd.Stderr.Write([]byte("ok 2"))
+		cur.summary.flush()
+		if cur.summary.matches != 2 {
+			t.Errorf("%s: %d matches, want 2", tt.name, cur.summary.matches)
+		}
+	}
+}
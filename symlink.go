@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// seedLinks records where every symlink in the watch dirs points, as the
+// baseline for relinked.
+func (r *runner) seedLinks() {
+	r.links = map[string]string{}
+	for _, dir := range r.watchDirs {
+		filepath.Walk(dir, func(p string, i os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if i.Mode()&os.ModeSymlink != 0 {
+				r.links[p], _ = os.Readlink(p)
+			}
+			return nil
+		})
+	}
+}
+
+// relinked decides whether an event on a symlink counts. A link only matters
+// when it's created, removed, or repointed at a different target; events that
+// leave the target alone are ignored. Events on anything else always count.
+func (r *runner) relinked(e fsnotify.Event) bool {
+	if r.links == nil {
+		return true
+	}
+
+	p := filepath.Clean(e.Name)
+	old, tracked := r.links[p]
+
+	i, err := os.Lstat(p)
+	if err != nil || i.Mode()&os.ModeSymlink == 0 {
+		delete(r.links, p)
+		return true
+	}
+
+	target, err := os.Readlink(p)
+	if err != nil {
+		return true
+	}
+	r.links[p] = target
+
+	if tracked && old == target {
+		return false
+	}
+	if tracked {
+		log.Infof("%s now points to %s (was %s)", p, target, old)
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Tags for the command's stdout and stderr in the command log, when they're
+// kept apart.
+const (
+	stdoutTag = "[stdout] "
+	stderrTag = "[stderr] "
+)
+
+// taggedLog is a command log that takes stdout and stderr separately and
+// tags each line with the stream it came from, so they can still be told
+// apart in the one file. exec copies the two streams in goroutines of their
+// own, so writes are serialized.
+type taggedLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// stream returns the writer for the stream tag names.
+func (l *taggedLog) stream(tag string) io.Writer {
+	return &tagWriter{log: l, tag: []byte(tag), bol: true}
+}
+
+// tagWriter is one stream of a taggedLog. A line split across writes is
+// only tagged at its start, so another stream's output can land in the
+// middle of it.
+type tagWriter struct {
+	log *taggedLog
+	tag []byte
+	bol bool
+}
+
+func (tw *tagWriter) Write(p []byte) (int, error) {
+	var b []byte
+	for rest := p; len(rest) > 0; {
+		if tw.bol {
+			b = append(b, tw.tag...)
+		}
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			b = append(b, rest...)
+			tw.bol = false
+			break
+		}
+		b = append(b, rest[:i+1]...)
+		rest = rest[i+1:]
+		tw.bol = true
+	}
+
+	tw.log.mu.Lock()
+	defer tw.log.mu.Unlock()
+	if _, err := tw.log.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestTaggedLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []struct{ tag, s string }
+		want   string
+	}{
+		{"one line each", []struct{ tag, s string }{{stdoutTag, "a\n"}, {stderrTag, "b\n"}}, "[stdout] a\n[stderr] b\n"},
+		{"several lines in a write", []struct{ tag, s string }{{stderrTag, "a\nb\n"}}, "[stderr] a\n[stderr] b\n"},
+		{"a line across writes", []struct{ tag, s string }{{stdoutTag, "a"}, {stdoutTag, "b\nc"}, {stdoutTag, "\n"}}, "[stdout] ab\n[stdout] c\n"},
+		{"no trailing newline", []struct{ tag, s string }{{stdoutTag, "a\nb"}}, "[stdout] a\n[stdout] b"},
+		{"empty write", []struct{ tag, s string }{{stdoutTag, ""}}, ""},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		l := &taggedLog{w: &out}
+		streams := map[string]io.Writer{stdoutTag: l.stream(stdoutTag), stderrTag: l.stream(stderrTag)}
+		for _, w := range tt.writes {
+			if n, err := streams[w.tag].Write([]byte(w.s)); n != len(w.s) || err != nil {
+				t.Errorf("%s: Write(%q) = %d, %v", tt.name, w.s, n, err)
+			}
+		}
+		if got := out.String(); got != tt.want {
+			t.Errorf("%s: log = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
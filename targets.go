@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runTargets runs the targets command and returns the paths it printed, one
+// per line, skipping blank lines and ones that don't exist.
+func (r *runner) runTargets() ([]string, error) {
+	args, err := splitCommand(r.targetsCmdStr, r.quoteStyle)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := localTransport{}.command(args)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	var targets []string
+	s := bufio.NewScanner(&out)
+	for s.Scan() {
+		p := strings.TrimSpace(s.Text())
+		if p == "" {
+			continue
+		}
+		if _, err := os.Stat(p); err != nil {
+			log.Debugf("skipping target %s: %s", p, err)
+			continue
+		}
+		targets = append(targets, filepath.Clean(p))
+	}
+	return targets, s.Err()
+}
+
+// refreshTargets reruns the targets command every targetsInterval, sending
+// each new list to the loop. A failed run is logged and skipped, leaving the
+// watches as they were.
+func (r *runner) refreshTargets() {
+	for range time.Tick(r.targetsInterval) {
+		targets, err := r.runTargets()
+		if err != nil {
+			log.Warnf("targets command failed, keeping the current watches: %s", err)
+			continue
+		}
+		r.targets <- targets
+	}
+}
+
+// applyTargets makes targets the watch dirs. A directory is watched with
+// everything under it; a file is watched through its directory, which is
+// then watched one level deep and only lets that file's changes through.
+func (r *runner) applyTargets(targets []string) {
+	r.lastTargets = targets
+	dirs := map[string]bool{}
+	files := map[string][]string{}
+	for _, p := range targets {
+		if i, err := os.Stat(p); err == nil && i.IsDir() {
+			dirs[p] = true
+		} else {
+			parent := filepath.Dir(p)
+			files[parent] = append(files[parent], globEscape(filepath.Base(p)))
+		}
+	}
+
+	r.roots.Lock()
+	r.watchDirs = nil
+	r.rootDepths = map[string]int{}
+	// only --exclude-in's patterns carry over to the new watch dirs.
+	prev := r.rootMatch
+	r.rootMatch = map[string]matcher{}
+	for dir := range dirs {
+		r.watchDirs = append(r.watchDirs, dir)
+		if m, ok := prev[filepath.Clean(dir)]; ok {
+			r.rootMatch[filepath.Clean(dir)] = matcher{ex: m.ex}
+		}
+	}
+	for parent, names := range files {
+		if dirs[parent] {
+			continue
+		}
+		r.watchDirs = append(r.watchDirs, parent)
+		r.rootDepths[parent] = 1
+		ex := r.match.ex
+		if m, ok := prev[filepath.Clean(parent)]; ok {
+			ex = m.ex
+		}
+		r.rootMatch[filepath.Clean(parent)] = matcher{in: names, ex: ex}
+	}
+	sort.Strings(r.watchDirs)
+	r.roots.Unlock()
+
+	for dir := range r.missing {
+		if !r.isRoot(dir) {
+			delete(r.missing, dir)
+		}
+	}
+}
+
+// globEscape escapes the characters filepath.Match treats specially, and the
+// braces expandBraces does.
+func globEscape(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		if strings.ContainsRune(`*?[{}\`, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
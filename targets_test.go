@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestGlobEscape(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"a.go", "a.go"},
+		{"a*.go", `a\*.go`},
+		{"a?.go", `a\?.go`},
+		{"[a].go", `\[a].go`},
+		{`a\b`, `a\\b`},
+		{"a{b,c}.go", `a\{b,c\}.go`},
+		{"{}", `\{\}`},
+	}
+	for _, tt := range tests {
+		got := globEscape(tt.name)
+		if got != tt.want {
+			t.Errorf("globEscape(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+
+		// the escaped name matches the name itself, and nothing else.
+		if !matchPattern(got, tt.name, tt.name) {
+			t.Errorf("%q doesn't match %q", got, tt.name)
+		}
+		if matchPattern(got, "ab.go", "ab.go") {
+			t.Errorf("%q matches ab.go", got)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import "errors"
+
+func keyMode(fd int) (func(), error) {
+	return nil, errors.New("only supported on linux and bsd")
+}
+
+func isTerminal(fd int) bool {
+	return false
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// parseUmask parses an octal umask, like 022 or 0077.
+func parseUmask(s string) (int, error) {
+	mask, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mask > 0777 {
+		return 0, fmt.Errorf("invalid umask: %s", s)
+	}
+	return int(mask), nil
+}
+
+// withUmask returns c run under sh with --umask, if set. The umask is set in
+// the child rather than in onchange, since it's process-wide and other
+// goroutines start commands and create files too. c must not have been
+// started or set up yet. A command that can't be found is left as is, for
+// Start to report.
+func (r *runner) withUmask(c *exec.Cmd) *exec.Cmd {
+	if r.umask < 0 {
+		return c
+	}
+	args := umaskArgs(r.umask)
+	if len(args) == 0 {
+		return c
+	}
+	if _, err := exec.LookPath(c.Path); err != nil {
+		return c
+	}
+	args = append(args, c.Args...)
+	return exec.Command(args[0], args[1:]...)
+}
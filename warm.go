@@ -0,0 +1,59 @@
+package main
+
+import "time"
+
+// startSpare starts a --warm-command spare to be promoted on the next change.
+func (r *runner) startSpare() error {
+	if r.warmCmdStr == "" || r.spare != nil {
+		return nil
+	}
+	spare := &run{id: newRunID(), cmdStr: r.warmCmdStr, warm: true, attempt: 1}
+	spare.log().Debugf("starting warm spare: %s", r.warmCmdStr)
+	return r.start(spare)
+}
+
+// promote swaps the warm spare in for cur, if one is ready, instead of
+// starting the command from scratch: the running command is stopped, the
+// spare is sent SIGUSR1, and a new spare is started for next time. It
+// reports whether cur was handled.
+func (r *runner) promote(cur *run) (bool, error) {
+	spare := r.spare
+	if spare == nil || !spare.ready || cur.cmdStr != r.cmdStr {
+		return false, nil
+	}
+
+	if err := r.stop(); err != nil {
+		return false, err
+	}
+	if err := promoteSignal(spare.cmd.Process.Pid); err != nil {
+		cur.log().Warnf("can't promote the warm spare, starting the command instead: %s", err)
+		return false, nil
+	}
+
+	r.spare = nil
+	spare.warm = false
+	spare.cmdStr = cur.cmdStr
+	spare.changed = cur.changed
+	r.arm(spare)
+	r.cur = spare
+	r.beginGroup(spare)
+	if !cur.pendingSince.IsZero() {
+		runLatency.observe(time.Since(cur.pendingSince))
+	}
+	spare.log().Infof("promoted warm spare for: %s", cur.cmdStr)
+	if r.readyAddr != "" {
+		go r.waitReady(spare)
+	}
+
+	return true, r.startSpare()
+}
+
+// stopSpare kills the warm spare, if there is one.
+func (r *runner) stopSpare() {
+	if r.spare == nil {
+		return
+	}
+	r.spare.cmd.Process.Kill()
+	r.spare.cleanup()
+	r.spare = nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// walk calls fn for every directory under dir, dir included, that isn't
+// excluded or past the depth limit.
+func (r *runner) walk(dir string, fn func(p string)) error {
+	return filepath.Walk(dir, func(p string, i os.FileInfo, err error) error {
+		if err != nil {
+			// things can vanish mid-walk; that's not worth failing over.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return &WalkError{Path: p, Err: err}
+		}
+
+		if !i.IsDir() {
+			return nil
+		}
+		if err := r.startupCheck("walking the watch dirs"); err != nil {
+			return err
+		}
+
+		if r.excludeDir(p) {
+			return filepath.SkipDir
+		}
+		if r.exclude(p) {
+			return nil
+		}
+		if r.tooDeep(p) {
+			return filepath.SkipDir
+		}
+
+		fn(p)
+		return nil
+	})
+}
+
+// watchSet walks the watch dirs and returns every directory that should be
+// watched.
+func (r *runner) watchSet() (map[string]bool, error) {
+	set := map[string]bool{}
+	add := func(p string) { set[p] = true }
+
+	for _, dir := range r.watchDirs {
+		if r.polled(dir) {
+			continue
+		}
+		if _, err := os.Stat(dir); err == nil {
+			set[dir] = true
+		}
+		if err := r.walk(dir, add); err != nil {
+			return nil, err
+		}
+	}
+
+	// the ignore files' directories are watched so edits to them are noticed.
+	var parents []string
+	for _, f := range r.ignoreFiles {
+		parents = append(parents, filepath.Dir(f))
+	}
+
+	// a dir that's already watched under another spelling, like "." for
+	// "/home/me/src", is skipped: the watcher would report its events under
+	// whichever name was added last.
+	abs := map[string]bool{}
+	for p := range set {
+		if a, err := filepath.Abs(p); err == nil {
+			abs[a] = true
+		}
+	}
+
+	for _, dir := range append(parents, r.globParents()...) {
+		if r.polled(dir) {
+			continue
+		}
+		if a, err := filepath.Abs(dir); err == nil && abs[a] && !set[dir] {
+			continue
+		}
+		if _, err := os.Stat(dir); err == nil {
+			set[dir] = true
+		}
+	}
+
+	return set, nil
+}
+
+// syncWatches brings the watcher in line with a fresh walk of the watch dirs,
+// adding directories that showed up and dropping ones that went away. It
+// returns what changed.
+func (r *runner) syncWatches(w *fsnotify.Watcher) (added, removed []string, err error) {
+	set, err := r.watchSet()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for p := range set {
+		if r.watched[p] {
+			continue
+		}
+		if err := r.addWatch(w, p); err != nil {
+			return added, removed, err
+		}
+		added = append(added, p)
+	}
+
+	for p := range r.watched {
+		if set[p] {
+			continue
+		}
+		log.Debugf("unwatching %s", p)
+		// the directory may already be gone, taking its watch with it.
+		w.Remove(p)
+		delete(r.watched, p)
+		removed = append(removed, p)
+	}
+
+	return added, removed, nil
+}
+
+// addTree watches dir and every directory under it that isn't watched yet.
+func (r *runner) addTree(w *fsnotify.Watcher, dir string) error {
+	var err error
+	walkErr := r.walk(dir, func(p string) {
+		if err != nil || r.watched[p] {
+			return
+		}
+		err = r.addWatch(w, p)
+	})
+	if err != nil {
+		return err
+	}
+	return walkErr
+}
+
+// created handles a directory showing up after startup. If it matches a watch
+// glob it becomes a watch dir of its own; either way, if it's inside a watch
+// dir, it and everything under it gets watched. With rootOnly, a directory
+// created right in a watch dir gets watched on its own, and deeper ones not
+// at all.
+func (r *runner) created(w *fsnotify.Watcher, p string) error {
+	if i, err := os.Stat(p); err != nil || !i.IsDir() {
+		return nil
+	}
+
+	if r.matchesGlob(p) && r.addRoot(p) {
+		log.Infof("watching new directory %s", p)
+	}
+
+	root, rel := r.root(p)
+	if root == "" || r.polled(p) {
+		return nil
+	}
+	if !r.rootOnly || rel == "." {
+		return r.addTree(w, p)
+	}
+	if strings.Contains(filepath.ToSlash(rel), "/") {
+		log.Debugf("not watching new directory %s: only the top level of a watch dir gets new watches", p)
+		return nil
+	}
+	if r.watched[p] || r.excludeDir(p) || r.exclude(p) || r.tooDeep(p) {
+		return nil
+	}
+	return r.addWatch(w, p)
+}
+
+// watchAddPause is how long --max-concurrent-watch-adds pauses between
+// batches of watches.
+const watchAddPause = 50 * time.Millisecond
+
+// addWatch registers p with the watcher. With watchAddBatch set, every batch
+// of that many adds is followed by a short pause, for kernels and
+// filesystems that choke on a rush of them.
+func (r *runner) addWatch(w *fsnotify.Watcher, p string) error {
+	if r.watchAddBatch > 0 && r.watchAdds > 0 && r.watchAdds%r.watchAddBatch == 0 {
+		time.Sleep(watchAddPause)
+	}
+	r.watchAdds++
+
+	log.Debugf("watching %s", p)
+	if err := w.Add(p); err != nil {
+		return &WatchError{Path: p, Err: err}
+	}
+	r.watched[p] = true
+	return nil
+}
+
+// expandGlobs adds every existing directory that matches a watch glob to the
+// watch dirs.
+func (r *runner) expandGlobs() {
+	for _, g := range r.watchGlobs {
+		matches, _ := filepath.Glob(filepath.Clean(g))
+		for _, m := range matches {
+			r.addRoot(m)
+		}
+	}
+}
+
+// addRoot makes dir a watch dir, if it is a directory and isn't one already.
+func (r *runner) addRoot(dir string) bool {
+	if i, err := os.Stat(dir); err != nil || !i.IsDir() {
+		return false
+	}
+
+	for _, d := range r.watchDirs {
+		if d == dir {
+			return false
+		}
+	}
+
+	r.roots.Lock()
+	r.watchDirs = append(r.watchDirs, dir)
+	r.roots.Unlock()
+	return true
+}
+
+// matchesGlob reports whether dir matches one of the watch globs.
+func (r *runner) matchesGlob(dir string) bool {
+	dir = filepath.Clean(dir)
+	for _, g := range r.watchGlobs {
+		if ok, _ := filepath.Match(filepath.Clean(g), dir); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// globParents returns the deepest directory of each watch glob that has no
+// wildcards in it. These are watched on their own, without recursing, so that
+// new matches are noticed as they're created.
+func (r *runner) globParents() []string {
+	var dirs []string
+	for _, g := range r.watchGlobs {
+		dir := filepath.Clean(g)
+		for strings.ContainsAny(dir, `*?[\`) {
+			dir = filepath.Dir(dir)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// drain moves events off the watcher into the runner's buffer as fast as they
+// arrive, so a burst doesn't back up into fsnotify while the run loop is busy
+// starting or killing a command. When the buffer fills, drain blocks and the
+// kernel queues events instead; on Linux that queue is capped by
+// /proc/sys/fs/inotify/max_queued_events, past which events are dropped.
+func (r *runner) drain(w *fsnotify.Watcher) {
+	for e := range w.Events {
+		r.events <- e
+	}
+}